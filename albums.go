@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultAlbumPageSize = 100
+	maxAlbumPageSize     = 1000
+)
+
+// albumSorts are the orders /api/albums can return albums in.
+var albumSorts = []string{"artist", "album_artist", "album", "year", "size", "duration", "added"}
+
+// AlbumQuery narrows, orders and pages a list of albums. Search terms must
+// each appear in the artist, album artist, album, genre, label or path,
+// ignoring case.
+type AlbumQuery struct {
+	Search     string
+	Formats    []string
+	Genres     []string
+	Synced     *bool
+	HasCover   *bool
+	Sort       string
+	Descending bool
+	Page       int
+	Limit      int
+}
+
+// AlbumPage is one page of albums and how many albums matched in total.
+type AlbumPage struct {
+	Albums []AlbumFolder `json:"albums"`
+	Total  int           `json:"total"`
+	Page   int           `json:"page"`
+	Limit  int           `json:"limit"`
+}
+
+func parseBoolParam(values url.Values, name string) (*bool, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return &parsed, nil
+}
+
+func parseIntParam(values url.Values, name string, fallback int) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return parsed, nil
+}
+
+// listParam reads a parameter that may be repeated or comma-separated.
+func listParam(values url.Values, name string) []string {
+	var list []string
+	for _, value := range values[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseAlbumQuery reads q, format and genre (repeated or comma-separated),
+// synced, has_cover, sort, order, page and limit.
+func parseAlbumQuery(values url.Values) (AlbumQuery, error) {
+	query := AlbumQuery{
+		Search:  values.Get("q"),
+		Formats: listParam(values, "format"),
+		Genres:  listParam(values, "genre"),
+		Sort:    values.Get("sort"),
+	}
+
+	var err error
+	if query.Synced, err = parseBoolParam(values, "synced"); err != nil {
+		return query, err
+	}
+	if query.HasCover, err = parseBoolParam(values, "has_cover"); err != nil {
+		return query, err
+	}
+
+	if query.Sort != "" && !containsString(albumSorts, query.Sort) {
+		return query, fmt.Errorf("unknown sort %q", query.Sort)
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("unknown order %q", values.Get("order"))
+	}
+
+	if query.Page, err = parseIntParam(values, "page", 1); err != nil {
+		return query, err
+	}
+	if query.Limit, err = parseIntParam(values, "limit", defaultAlbumPageSize); err != nil {
+		return query, err
+	}
+	if query.Limit > maxAlbumPageSize {
+		query.Limit = maxAlbumPageSize
+	}
+	return query, nil
+}
+
+func (q AlbumQuery) matches(album AlbumFolder) bool {
+	if len(q.Formats) > 0 && !containsFold(q.Formats, album.Format) {
+		return false
+	}
+	if len(q.Genres) > 0 && !containsFold(q.Genres, album.Genre) {
+		return false
+	}
+	if q.HasCover != nil && album.HasCover != *q.HasCover {
+		return false
+	}
+	haystack := strings.ToLower(strings.Join([]string{album.Artist, album.AlbumArtist, album.Album, album.Genre, album.Label, album.Path}, "\n"))
+	for _, term := range strings.Fields(strings.ToLower(q.Search)) {
+		if !strings.Contains(haystack, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// albumLess orders albums by field, falling back to the path so pages are
+// stable.
+func albumLess(field string, a, b AlbumFolder) bool {
+	switch field {
+	case "artist":
+		if c := strings.Compare(strings.ToLower(a.Artist), strings.ToLower(b.Artist)); c != 0 {
+			return c < 0
+		}
+		if c := strings.Compare(strings.ToLower(a.Album), strings.ToLower(b.Album)); c != 0 {
+			return c < 0
+		}
+	case "album_artist":
+		if c := strings.Compare(strings.ToLower(sortArtist(a)), strings.ToLower(sortArtist(b))); c != 0 {
+			return c < 0
+		}
+		if a.Year != b.Year {
+			return a.Year < b.Year
+		}
+		if c := strings.Compare(strings.ToLower(a.Album), strings.ToLower(b.Album)); c != 0 {
+			return c < 0
+		}
+	case "album":
+		if c := strings.Compare(strings.ToLower(a.Album), strings.ToLower(b.Album)); c != 0 {
+			return c < 0
+		}
+		if c := strings.Compare(strings.ToLower(a.Artist), strings.ToLower(b.Artist)); c != 0 {
+			return c < 0
+		}
+	case "year":
+		if a.Year != b.Year {
+			return a.Year < b.Year
+		}
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+	case "size":
+		if a.SizeMB != b.SizeMB {
+			return a.SizeMB < b.SizeMB
+		}
+	case "duration":
+		if a.DurationSec != b.DurationSec {
+			return a.DurationSec < b.DurationSec
+		}
+	case "added":
+		if !a.AddedAt.Equal(b.AddedAt) {
+			return a.AddedAt.Before(b.AddedAt)
+		}
+	}
+	return a.Path < b.Path
+}
+
+// sortArtist is the album artist, or the artist for albums without one.
+func sortArtist(album AlbumFolder) string {
+	if album.AlbumArtist != "" {
+		return album.AlbumArtist
+	}
+	return album.Artist
+}
+
+// queryAlbums filters, sorts and pages albums. synced, when set, tells
+// whether an album is on the target; it is only called for albums that pass
+// the other filters, and marks the returned albums.
+func queryAlbums(albums []AlbumFolder, query AlbumQuery, synced func(AlbumFolder) bool) AlbumPage {
+	matched := []AlbumFolder{}
+	for _, album := range albums {
+		if !query.matches(album) {
+			continue
+		}
+		if synced != nil {
+			album.IsSynced = synced(album)
+			if query.Synced != nil && album.IsSynced != *query.Synced {
+				continue
+			}
+		}
+		matched = append(matched, album)
+	}
+
+	// Without a sort the scan order is kept
+	if query.Sort != "" {
+		sort.SliceStable(matched, func(i, j int) bool {
+			if query.Descending {
+				return albumLess(query.Sort, matched[j], matched[i])
+			}
+			return albumLess(query.Sort, matched[i], matched[j])
+		})
+	} else if query.Descending {
+		for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+			matched[i], matched[j] = matched[j], matched[i]
+		}
+	}
+
+	page := AlbumPage{Albums: []AlbumFolder{}, Total: len(matched), Page: query.Page, Limit: query.Limit}
+	start := (query.Page - 1) * query.Limit
+	if start < len(matched) {
+		end := min(start+query.Limit, len(matched))
+		page.Albums = matched[start:end]
+	}
+	return page
+}
+
+// handleAlbums lists albums from the catalog for directory, or from the last
+// scan when no directory is given. With a target, albums are marked synced
+// and can be filtered on it.
+func (s *Server) handleAlbums(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	values := r.URL.Query()
+	query, err := parseAlbumQuery(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var albums []AlbumFolder
+	if values.Get("directory") != "" {
+		directory, err := resolveAllowed(values.Get("directory"), s.libraryRoots())
+		if err != nil {
+			writePathError(w, err)
+			return
+		}
+		albums = s.catalog.albums(directory)
+	} else {
+		s.lastScanMutex.RLock()
+		albums = s.lastScan
+		s.lastScanMutex.RUnlock()
+	}
+
+	var synced func(AlbumFolder) bool
+	if values.Get("target") != "" {
+		target, err := resolveAllowed(values.Get("target"), s.targetRoots())
+		if err != nil {
+			writePathError(w, err)
+			return
+		}
+		index := s.newSyncIndex(target)
+		synced = func(album AlbumFolder) bool { return index.synced(album.Path) }
+	} else if query.Synced != nil {
+		http.Error(w, "Filtering on synced needs a target", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queryAlbums(albums, query, synced))
+}
+
+// SelectionSummary totals a selection of albums, for sizing it against a
+// device by playing time as well as by space.
+type SelectionSummary struct {
+	Albums      int        `json:"albums"`
+	SizeMB      float64    `json:"size_mb"`
+	DurationSec float64    `json:"duration_sec"`
+	Missing     []AlbumRef `json:"missing"`
+}
+
+// selectionSummary totals the albums at paths, taking them from the last
+// scan where possible. Paths outside the library roots are not read and are
+// listed as missing, like albums that cannot be read.
+func (s *Server) selectionSummary(paths []string) SelectionSummary {
+	s.lastScanMutex.RLock()
+	byPath := make(map[string]AlbumFolder, len(s.lastScan))
+	for _, album := range s.lastScan {
+		byPath[album.Path] = album
+	}
+	s.lastScanMutex.RUnlock()
+
+	libraryRoots := s.libraryRoots()
+	summary := SelectionSummary{Missing: []AlbumRef{}}
+	for _, path := range paths {
+		resolved, err := resolveAllowed(path, libraryRoots)
+		if err != nil {
+			summary.Missing = append(summary.Missing, AlbumRef{Path: path})
+			continue
+		}
+		album, ok := byPath[path]
+		if !ok {
+			if album, ok = s.albumFolder(resolved); !ok {
+				summary.Missing = append(summary.Missing, AlbumRef{Path: path})
+				continue
+			}
+		}
+		summary.Albums++
+		summary.SizeMB += album.SizeMB
+		summary.DurationSec += album.DurationSec
+	}
+	return summary
+}
+
+// handleAlbumSummary totals a selection given like a sync set: album
+// references and optional rules.
+func (s *Server) handleAlbumSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Albums []AlbumRef `json:"albums"`
+		Rules  *RuleSet   `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Rules != nil {
+		if err := req.Rules.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	paths, missing, _ := s.resolveAlbumRefs(req.Albums)
+	if req.Rules != nil {
+		paths = append(paths, s.ruleSelection(*req.Rules, paths)...)
+	}
+
+	summary := s.selectionSummary(paths)
+	summary.Missing = append(missing, summary.Missing...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryAlbums(t *testing.T) {
+	now := time.Now()
+	albums := []AlbumFolder{
+		{Path: "/music/Beatles/Abbey Road", Artist: "The Beatles", Album: "Abbey Road", Format: "flac", HasCover: true, SizeMB: 300, AddedAt: now.AddDate(0, 0, -10), Year: 1969, Genre: "Rock"},
+		{Path: "/music/Beatles/Help", Artist: "The Beatles", Album: "Help!", Format: "mp3", SizeMB: 80, AddedAt: now.AddDate(0, 0, -1), Year: 1965, Genre: "Rock"},
+		{Path: "/music/Air/Moon Safari", Artist: "Air", Album: "Moon Safari", Format: "mp3", HasCover: true, SizeMB: 100, AddedAt: now.AddDate(0, 0, -5), Year: 1998, Label: "Source"},
+		{Path: "/music/Miles Davis/Kind of Blue", Artist: "Miles Davis", Album: "Kind of Blue", Format: "flac", SizeMB: 250, AddedAt: now, Year: 1959, Genre: "Jazz"},
+	}
+
+	query := func(raw string) AlbumPage {
+		t.Helper()
+		values, _ := url.ParseQuery(raw)
+		q, err := parseAlbumQuery(values)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", raw, err)
+		}
+		return queryAlbums(albums, q, nil)
+	}
+	paths := func(page AlbumPage) []string {
+		var result []string
+		for _, album := range page.Albums {
+			result = append(result, album.Path)
+		}
+		return result
+	}
+
+	// Every search term must match, in any field
+	if page := query("q=beatles+road"); page.Total != 1 || page.Albums[0].Album != "Abbey Road" {
+		t.Errorf("Expected Abbey Road for 'beatles road', got %v", paths(page))
+	}
+	if page := query("q=MOON"); page.Total != 1 {
+		t.Errorf("Expected a case-insensitive match, got %v", paths(page))
+	}
+
+	if page := query("format=flac&has_cover=false"); page.Total != 1 || page.Albums[0].Artist != "Miles Davis" {
+		t.Errorf("Expected only Kind of Blue, got %v", paths(page))
+	}
+
+	page := query("sort=artist")
+	if got := paths(page); got[0] != "/music/Air/Moon Safari" || got[1] != "/music/Miles Davis/Kind of Blue" || got[2] != "/music/Beatles/Abbey Road" {
+		t.Errorf("Unexpected artist order %v", got)
+	}
+	if page := query("sort=size&order=desc"); page.Albums[0].SizeMB != 300 || page.Albums[3].SizeMB != 80 {
+		t.Errorf("Unexpected size order %v", paths(page))
+	}
+	if page := query("sort=added&order=desc"); page.Albums[0].Artist != "Miles Davis" {
+		t.Errorf("Expected the newest album first, got %v", paths(page))
+	}
+	if page := query("sort=year"); page.Albums[0].Album != "Kind of Blue" || page.Albums[3].Album != "Moon Safari" {
+		t.Errorf("Unexpected year order %v", paths(page))
+	}
+	if page := query("genre=rock&q=help"); page.Total != 1 || page.Albums[0].Album != "Help!" {
+		t.Errorf("Expected Help! among the rock albums, got %v", paths(page))
+	}
+	if page := query("q=source"); page.Total != 1 || page.Albums[0].Album != "Moon Safari" {
+		t.Errorf("Expected a match on the label, got %v", paths(page))
+	}
+
+	// Pages past the end are empty, not an error
+	page = query("sort=album&limit=3&page=2")
+	if page.Total != 4 || len(page.Albums) != 1 || page.Albums[0].Album != "Moon Safari" {
+		t.Errorf("Expected the last album on page 2, got %+v", page)
+	}
+	if page := query("limit=3&page=5"); page.Total != 4 || len(page.Albums) != 0 {
+		t.Errorf("Expected an empty page, got %v", paths(page))
+	}
+
+	for _, raw := range []string{"sort=rating", "order=up", "page=0", "limit=x", "synced=maybe"} {
+		values, _ := url.ParseQuery(raw)
+		if _, err := parseAlbumQuery(values); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestHandleAlbumsSyncedNeedsTarget(t *testing.T) {
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		lastScan:         []AlbumFolder{{Path: "/music/a", Artist: "A"}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/albums?synced=true", nil)
+	w := httptest.NewRecorder()
+	server.handleAlbums(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a target, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/albums", nil)
+	w = httptest.NewRecorder()
+	server.handleAlbums(w, req)
+	var page AlbumPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if page.Total != 1 || page.Page != 1 || page.Limit != defaultAlbumPageSize {
+		t.Errorf("Expected the last scan on one default page, got %+v", page)
+	}
+}
+
+func TestAlbumDurationsAndSummary(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "albums_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// 10 s of FLAC and 2.6 s of 128 kbps MP3
+	library := filepath.Join(tempDir, "library")
+	lossless := filepath.Join(library, "Artist", "Lossless")
+	lossy := filepath.Join(library, "Artist", "Lossy")
+	createAlbum(t, lossless, nil)
+	createAlbum(t, lossy, nil)
+	os.WriteFile(filepath.Join(lossless, "01.flac"), flacStreamInfo(44100, 441000), 0644)
+	os.WriteFile(filepath.Join(lossy, "01.mp3"), mp3Frames(100, nil), 0644)
+
+	outside := filepath.Join(tempDir, "private", "Album")
+	createAlbum(t, outside, nil)
+	os.WriteFile(filepath.Join(outside, "01.flac"), flacStreamInfo(44100, 441000), 0644)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+	}
+	if err := server.updateSettings(func(settings *AppSettings) error {
+		settings.LibraryRoots = []string{library}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	server.lastScan = server.scanMusicFolders(library)
+	if len(server.lastScan) != 2 {
+		t.Fatalf("Expected 2 albums, got %d", len(server.lastScan))
+	}
+
+	flac, mp3 := server.lastScan[0], server.lastScan[1]
+	if flac.DurationSec != 10 || flac.Codec != "flac" || !flac.Lossless {
+		t.Errorf("Unexpected FLAC album: %+v", flac)
+	}
+	if math.Abs(mp3.DurationSec-2.606) > 0.01 || mp3.Codec != "mp3" || mp3.Lossless || mp3.BitrateKbps != 128 {
+		t.Errorf("Unexpected MP3 album: %+v", mp3)
+	}
+
+	// A selection by path and by fingerprint, plus one that is gone and one
+	// outside the library roots, which is not read
+	body := fmt.Sprintf(`{"albums": [{"path": %q}, {"fingerprint": %q}, {"path": "/gone"}, {"path": %q}]}`, lossless, mp3.Fingerprint, outside)
+	req := httptest.NewRequest(http.MethodPost, "/api/albums/summary", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.handleAlbumSummary(w, req)
+
+	var summary SelectionSummary
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatalf("Failed to decode summary: %v", err)
+	}
+	if summary.Albums != 2 || math.Abs(summary.DurationSec-12.606) > 0.01 || len(summary.Missing) != 2 || summary.Missing[1].Path != outside {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	// The target reports its playing time too
+	target := filepath.Join(tempDir, "target")
+	createAlbum(t, filepath.Join(target, "Lossless"), nil)
+	os.WriteFile(filepath.Join(target, "Lossless", "01.flac"), flacStreamInfo(44100, 441000), 0644)
+	inventory, err := server.targetInventory(target, "")
+	if err != nil {
+		t.Fatalf("Failed to take inventory: %v", err)
+	}
+	if inventory.DurationSec != 10 || inventory.Folders[0].DurationSec != 10 {
+		t.Errorf("Expected 10 s on the target, got %+v", inventory)
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AudioInfo describes the audio stream in a file, as far as its headers tell.
+// Format is the kind of file found by its content (ALAC in an MP4 container
+// is "alac", AAC is "m4a"); Codec is the stream's encoding.
+type AudioInfo struct {
+	Format      string
+	Codec       string
+	DurationSec float64
+	BitrateKbps int
+	SampleRate  int
+	Channels    int
+}
+
+var errUnknownAudio = errors.New("unrecognized audio stream")
+
+// losslessCodecs keep the original audio bit for bit.
+var losslessCodecs = []string{"flac", "alac", "pcm", "ape", "wv", "dsd"}
+
+// audioTotals adds up the streams of several audio files.
+type audioTotals struct {
+	durationSec float64
+	timedBytes  int64 // size of the files whose duration is known
+	codecs      map[string]int
+	formats     map[string]int
+	lossless    bool
+	files       int
+}
+
+func (t *audioTotals) add(info AudioInfo, size int64) {
+	if t.codecs == nil {
+		t.codecs = make(map[string]int)
+		t.formats = make(map[string]int)
+		t.lossless = true
+	}
+	t.files++
+	t.durationSec += info.DurationSec
+	if info.DurationSec > 0 {
+		t.timedBytes += size
+	}
+	t.codecs[info.Codec]++
+	t.formats[info.Format]++
+	t.lossless = t.lossless && containsString(losslessCodecs, info.Codec)
+}
+
+// bitrateKbps is the average bitrate over the files with a known duration.
+func (t audioTotals) bitrateKbps() int {
+	if t.durationSec == 0 {
+		return 0
+	}
+	return int(math.Round(float64(t.timedBytes) * 8 / t.durationSec / 1000))
+}
+
+// extensionFormats names the format a file claims by its extension, where
+// that differs from the extension itself.
+var extensionFormats = map[string]string{"aif": "aiff", "aifc": "aiff", "oga": "ogg"}
+
+// formatFromExtension is the format path claims to be by its extension.
+func formatFromExtension(path string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if format, ok := extensionFormats[ext]; ok {
+		return format
+	}
+	return ext
+}
+
+// asfHeader starts every WMA (ASF) file.
+var asfHeader = []byte{0x30, 0x26, 0xb2, 0x75, 0x8e, 0x66, 0xcf, 0x11}
+
+// readAudioInfo identifies an audio file by its content, not its extension,
+// and reads its stream headers: MP3 (with Xing/Info or VBRI for variable
+// bitrates), FLAC, MP4/M4A, WAV, AIFF, Ogg Vorbis and Opus, and DSF. APE,
+// WavPack, raw AAC and WMA are recognized without a duration. Files that are
+// not recognized get the format and codec their extension claims, and an
+// error.
+func readAudioInfo(path string) (AudioInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		claimed := formatFromExtension(path)
+		return AudioInfo{Format: claimed, Codec: claimed}, err
+	}
+	defer f.Close()
+	return readAudioInfoFrom(f)
+}
+
+// readAudioInfoFrom is readAudioInfo for a file that is already open, read
+// from its start.
+func readAudioInfoFrom(f *os.File) (AudioInfo, error) {
+	claimed := formatFromExtension(f.Name())
+	fallback := AudioInfo{Format: claimed, Codec: claimed}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fallback, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return fallback, err
+	}
+	size := stat.Size()
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return fallback, errUnknownAudio
+	}
+
+	var info AudioInfo
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		info, err = readFLACInfo(f)
+		info.Format = "flac"
+	case bytes.Equal(header[4:8], []byte("ftyp")):
+		info, err = readMP4Info(f, size)
+		info.Format = "m4a"
+		if info.Codec == "alac" {
+			info.Format = "alac"
+		}
+	case bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		info, err = readWAVInfo(f)
+		info.Format = "wav"
+	case bytes.HasPrefix(header, []byte("FORM")) && (bytes.Equal(header[8:12], []byte("AIFF")) || bytes.Equal(header[8:12], []byte("AIFC"))):
+		info, err = readAIFFInfo(f)
+		info.Format = "aiff"
+	case bytes.HasPrefix(header, []byte("OggS")):
+		info, err = readOggInfo(f, size)
+		info.Format = "ogg"
+		if info.Codec == "opus" {
+			info.Format = "opus"
+		}
+	case bytes.HasPrefix(header, []byte("DSD ")):
+		info, err = readDSFInfo(f)
+		info.Format = "dsf"
+	case bytes.HasPrefix(header, []byte("MAC ")):
+		info = AudioInfo{Format: "ape", Codec: "ape"}
+	case bytes.HasPrefix(header, []byte("wvpk")):
+		info = AudioInfo{Format: "wv", Codec: "wv"}
+	case bytes.HasPrefix(header, asfHeader):
+		info = AudioInfo{Format: "wma", Codec: "wma"}
+	case header[0] == 0xff && header[1]&0xf6 == 0xf0:
+		// ADTS frames carry the MPEG sync word with layer 0
+		info = AudioInfo{Format: "aac", Codec: "aac"}
+	case bytes.HasPrefix(header, []byte("ID3")) || header[0] == 0xff || claimed == "mp3":
+		info, err = readMP3Info(f, header, size)
+		info.Format = "mp3"
+	default:
+		return fallback, errUnknownAudio
+	}
+	if err != nil {
+		return fallback, err
+	}
+
+	// Average bitrate from the file size when the stream does not say
+	if info.BitrateKbps == 0 && info.DurationSec > 0 {
+		info.BitrateKbps = int(math.Round(float64(size) * 8 / info.DurationSec / 1000))
+	}
+	return info, nil
+}
+
+var (
+	mp3Bitrates = map[[2]int][]int{
+		{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	mp3SampleRates = map[int][]int{
+		1: {44100, 48000, 32000},
+		2: {22050, 24000, 16000},
+		3: {11025, 12000, 8000}, // MPEG 2.5
+	}
+)
+
+// mp3Frame is a decoded MPEG audio frame header.
+type mp3Frame struct {
+	version    int // 1, 2, or 3 for MPEG 2.5
+	layer      int
+	bitrate    int // kbps
+	sampleRate int
+	padding    int
+	mono       bool
+}
+
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+
+	var frame mp3Frame
+	switch (b[1] >> 3) & 3 {
+	case 0:
+		frame.version = 3
+	case 2:
+		frame.version = 2
+	case 3:
+		frame.version = 1
+	default:
+		return frame, false
+	}
+	layerBits := (b[1] >> 1) & 3
+	if layerBits == 0 {
+		return frame, false
+	}
+	frame.layer = 4 - int(layerBits)
+
+	bitrateIndex := int(b[2] >> 4)
+	rateIndex := int(b[2]>>2) & 3
+	if bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return frame, false
+	}
+	tableVersion := min(frame.version, 2)
+	frame.bitrate = mp3Bitrates[[2]int{tableVersion, frame.layer}][bitrateIndex]
+	frame.sampleRate = mp3SampleRates[frame.version][rateIndex]
+	frame.padding = int(b[2]>>1) & 1
+	frame.mono = b[3]>>6 == 3
+	return frame, true
+}
+
+func (f mp3Frame) samples() int {
+	switch {
+	case f.layer == 1:
+		return 384
+	case f.layer == 3 && f.version != 1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+func (f mp3Frame) length() int {
+	if f.layer == 1 {
+		return (12*f.bitrate*1000/f.sampleRate + f.padding) * 4
+	}
+	return f.samples()/8*f.bitrate*1000/f.sampleRate + f.padding
+}
+
+// sideInfoSize is the length of the Layer III side information, after which
+// an encoder puts its Xing/Info header.
+func (f mp3Frame) sideInfoSize() int {
+	switch {
+	case f.version == 1 && !f.mono:
+		return 32
+	case f.version == 1 || !f.mono:
+		return 17
+	default:
+		return 9
+	}
+}
+
+// mp3SearchLimit bounds how far past the tags the first frame is looked for.
+const mp3SearchLimit = 64 * 1024
+
+func readMP3Info(f *os.File, header []byte, size int64) (AudioInfo, error) {
+	var start int64
+	if bytes.HasPrefix(header, []byte("ID3")) {
+		start = int64(10 + syncsafe(header[6:10]))
+		if header[5]&0x10 != 0 { // footer
+			start += 10
+		}
+	}
+
+	buf := make([]byte, mp3SearchLimit)
+	n, _ := f.ReadAt(buf, start)
+	buf = buf[:n]
+
+	// A frame header is only trusted when the next frame follows it
+	offset := -1
+	var frame mp3Frame
+	for i := 0; i+4 <= len(buf); i++ {
+		candidate, ok := parseMP3Frame(buf[i:])
+		if !ok {
+			continue
+		}
+		next := i + candidate.length()
+		if next+4 <= len(buf) {
+			if _, ok := parseMP3Frame(buf[next:]); !ok {
+				continue
+			}
+		}
+		offset, frame = i, candidate
+		break
+	}
+	if offset < 0 {
+		return AudioInfo{}, errUnknownAudio
+	}
+
+	info := AudioInfo{
+		Codec:      []string{"", "mp1", "mp2", "mp3"}[frame.layer],
+		SampleRate: frame.sampleRate,
+		Channels:   2,
+	}
+	if frame.mono {
+		info.Channels = 1
+	}
+
+	audioBytes := size - start - int64(offset)
+	trailer := make([]byte, 3)
+	if _, err := f.ReadAt(trailer, size-128); err == nil && string(trailer) == "TAG" {
+		audioBytes -= 128
+	}
+
+	// Variable bitrate files say how many frames they hold
+	frames, streamBytes := 0, int64(0)
+	body := buf[offset:]
+	if xing := 4 + frame.sideInfoSize(); len(body) >= xing+16 &&
+		(bytes.Equal(body[xing:xing+4], []byte("Xing")) || bytes.Equal(body[xing:xing+4], []byte("Info"))) {
+		flags := binary.BigEndian.Uint32(body[xing+4:])
+		field := xing + 8
+		if flags&1 != 0 {
+			frames = int(binary.BigEndian.Uint32(body[field:]))
+			field += 4
+		}
+		if flags&2 != 0 {
+			streamBytes = int64(binary.BigEndian.Uint32(body[field:]))
+		}
+	} else if len(body) >= 36+18 && bytes.Equal(body[36:40], []byte("VBRI")) {
+		streamBytes = int64(binary.BigEndian.Uint32(body[36+10:]))
+		frames = int(binary.BigEndian.Uint32(body[36+14:]))
+	}
+
+	if frames > 0 {
+		info.DurationSec = float64(frames) * float64(frame.samples()) / float64(frame.sampleRate)
+		if streamBytes == 0 {
+			streamBytes = audioBytes
+		}
+		info.BitrateKbps = int(math.Round(float64(streamBytes) * 8 / info.DurationSec / 1000))
+		return info, nil
+	}
+
+	// Constant bitrate: the length follows from the size
+	info.BitrateKbps = frame.bitrate
+	info.DurationSec = float64(audioBytes) * 8 / float64(frame.bitrate*1000)
+	return info, nil
+}
+
+// readFLACInfo decodes STREAMINFO, which is always the first metadata block.
+func readFLACInfo(f *os.File) (AudioInfo, error) {
+	block := make([]byte, 4+34)
+	if _, err := f.ReadAt(block, 4); err != nil {
+		return AudioInfo{}, errUnknownAudio
+	}
+	if block[0]&0x7f != 0 {
+		return AudioInfo{}, errUnknownAudio
+	}
+	b := block[4:]
+
+	info := AudioInfo{
+		Codec:      "flac",
+		SampleRate: int(b[10])<<12 | int(b[11])<<4 | int(b[12])>>4,
+		Channels:   int(b[12]>>1&7) + 1,
+	}
+	totalSamples := int64(b[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(b[14:18]))
+	if info.SampleRate > 0 {
+		info.DurationSec = float64(totalSamples) / float64(info.SampleRate)
+	}
+	return info, nil
+}
+
+// mp4Containers are the atoms walked on the way to mvhd and stsd.
+var mp4Containers = map[string]bool{"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true}
+
+// maxMP4MetadataSize bounds how much of an MP4 file's moov atom is read.
+const maxMP4MetadataSize = 16 * 1024 * 1024
+
+// readMP4Moov finds the moov atom, before or after the media data, and
+// returns its contents.
+func readMP4Moov(f *os.File, size int64) ([]byte, error) {
+	header := make([]byte, 16)
+	for offset := int64(0); offset+8 <= size; {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			break
+		}
+		atomSize := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch atomSize {
+		case 0:
+			atomSize = size - offset
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, errUnknownAudio
+			}
+			atomSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if atomSize < headerSize {
+			break
+		}
+
+		if string(header[4:8]) == "moov" {
+			if atomSize > maxMP4MetadataSize {
+				return nil, errUnknownAudio
+			}
+			moov := make([]byte, atomSize-headerSize)
+			if _, err := f.ReadAt(moov, offset+headerSize); err != nil {
+				return nil, errUnknownAudio
+			}
+			return moov, nil
+		}
+		offset += atomSize
+	}
+	return nil, errUnknownAudio
+}
+
+// readMP4Info reads the duration from mvhd and the codec from the first
+// sample description.
+func readMP4Info(f *os.File, size int64) (AudioInfo, error) {
+	moov, err := readMP4Moov(f, size)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	var info AudioInfo
+	parseMP4Atoms(moov, &info)
+	if info.Codec == "" {
+		info.Codec = "mp4"
+	}
+	return info, nil
+}
+
+func parseMP4Atoms(data []byte, info *AudioInfo) {
+	for len(data) >= 8 {
+		atomSize := int(binary.BigEndian.Uint32(data))
+		name := string(data[4:8])
+		if atomSize < 8 || atomSize > len(data) {
+			return
+		}
+		body := data[8:atomSize]
+		data = data[atomSize:]
+
+		switch {
+		case mp4Containers[name]:
+			parseMP4Atoms(body, info)
+		case name == "mvhd" && len(body) >= 20:
+			var timescale uint32
+			var duration uint64
+			if body[0] == 1 && len(body) >= 32 {
+				timescale = binary.BigEndian.Uint32(body[20:])
+				duration = binary.BigEndian.Uint64(body[24:])
+			} else {
+				timescale = binary.BigEndian.Uint32(body[12:])
+				duration = uint64(binary.BigEndian.Uint32(body[16:]))
+			}
+			if timescale > 0 {
+				info.DurationSec = float64(duration) / float64(timescale)
+			}
+		case name == "stsd" && info.Codec == "" && len(body) >= 8+36:
+			// The audio sample entry follows the version, flags and count
+			entry := body[8:]
+			switch format := string(entry[4:8]); format {
+			case "mp4a":
+				info.Codec = "aac"
+			case "alac":
+				info.Codec = "alac"
+			default:
+				info.Codec = strings.TrimSpace(format)
+			}
+			info.Channels = int(binary.BigEndian.Uint16(entry[24:]))
+			info.SampleRate = int(binary.BigEndian.Uint32(entry[32:]) >> 16)
+		}
+	}
+}
+
+// readWAVInfo reads the fmt chunk and the size of the data chunk.
+func readWAVInfo(f *os.File) (AudioInfo, error) {
+	info := AudioInfo{Codec: "wav"}
+	var byteRate uint32
+
+	chunk := make([]byte, 8)
+	for offset := int64(12); ; {
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return AudioInfo{}, errUnknownAudio
+		}
+		length := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch string(chunk[:4]) {
+		case "fmt ":
+			format := make([]byte, 16)
+			if _, err := f.ReadAt(format, offset+8); err != nil {
+				return AudioInfo{}, errUnknownAudio
+			}
+			if binary.LittleEndian.Uint16(format) == 1 {
+				info.Codec = "pcm"
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(format[2:]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(format[4:]))
+			byteRate = binary.LittleEndian.Uint32(format[8:])
+		case "data":
+			if byteRate == 0 {
+				return AudioInfo{}, errUnknownAudio
+			}
+			info.DurationSec = float64(length) / float64(byteRate)
+			info.BitrateKbps = int(byteRate * 8 / 1000)
+			return info, nil
+		}
+		// Chunks are padded to an even length
+		offset += 8 + length + length%2
+	}
+}
+
+// readAIFFInfo reads the COMM chunk, whose sample rate is an 80-bit
+// extended float.
+func readAIFFInfo(f *os.File) (AudioInfo, error) {
+	info := AudioInfo{Codec: "pcm"}
+
+	chunk := make([]byte, 8)
+	for offset := int64(12); ; {
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return AudioInfo{}, errUnknownAudio
+		}
+		length := int64(binary.BigEndian.Uint32(chunk[4:]))
+
+		if string(chunk[:4]) == "COMM" {
+			comm := make([]byte, 22)
+			if _, err := f.ReadAt(comm[:min(length, 22)], offset+8); err != nil {
+				return AudioInfo{}, errUnknownAudio
+			}
+			info.Channels = int(binary.BigEndian.Uint16(comm))
+			frames := binary.BigEndian.Uint32(comm[2:])
+			exponent := int(binary.BigEndian.Uint16(comm[8:])&0x7fff) - 16383 - 63
+			mantissa := binary.BigEndian.Uint64(comm[10:])
+			info.SampleRate = int(math.Ldexp(float64(mantissa), exponent))
+
+			// AIFC names its compression after the sample rate
+			if compression := string(comm[18:22]); length >= 22 && compression != "NONE" && compression != "sowt" {
+				info.Codec = strings.ToLower(strings.TrimSpace(compression))
+			}
+			if info.SampleRate > 0 {
+				info.DurationSec = float64(frames) / float64(info.SampleRate)
+			}
+			return info, nil
+		}
+		offset += 8 + length + length%2
+	}
+}
+
+// oggTailSize is how much of the end of an Ogg file is searched for the last
+// page, whose granule position gives the length.
+const oggTailSize = 64 * 1024
+
+// readOggInfo reads the identification header in the first page (Opus or
+// Vorbis) and the granule position of the last page.
+func readOggInfo(f *os.File, size int64) (AudioInfo, error) {
+	page := make([]byte, 27+255+30)
+	n, _ := f.ReadAt(page, 0)
+	page = page[:n]
+	if len(page) < 27 || len(page) < 27+int(page[26]) {
+		return AudioInfo{}, errUnknownAudio
+	}
+	packet := page[27+int(page[26]):]
+
+	var info AudioInfo
+	var preSkip uint64
+	switch {
+	case len(packet) >= 19 && bytes.HasPrefix(packet, []byte("OpusHead")):
+		// Opus always runs at 48 kHz; the header names the input's rate
+		info.Codec = "opus"
+		info.Channels = int(packet[9])
+		info.SampleRate = 48000
+		preSkip = uint64(binary.LittleEndian.Uint16(packet[10:]))
+	case len(packet) >= 16 && bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		info.Codec = "vorbis"
+		info.Channels = int(packet[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(packet[12:]))
+	default:
+		return AudioInfo{}, errUnknownAudio
+	}
+
+	tailStart := max(size-oggTailSize, 0)
+	tail := make([]byte, size-tailStart)
+	if _, err := f.ReadAt(tail, tailStart); err != nil {
+		return info, nil
+	}
+	last := bytes.LastIndex(tail, []byte("OggS"))
+	if last >= 0 && last+14 <= len(tail) && info.SampleRate > 0 {
+		granule := binary.LittleEndian.Uint64(tail[last+6:])
+		if granule > preSkip && granule != math.MaxUint64 {
+			info.DurationSec = float64(granule-preSkip) / float64(info.SampleRate)
+		}
+	}
+	return info, nil
+}
+
+// readDSFInfo reads the fmt chunk that follows the 28-byte DSD chunk.
+func readDSFInfo(f *os.File) (AudioInfo, error) {
+	chunk := make([]byte, 52)
+	if _, err := f.ReadAt(chunk, 28); err != nil || string(chunk[:4]) != "fmt " {
+		return AudioInfo{}, errUnknownAudio
+	}
+
+	info := AudioInfo{
+		Codec:      "dsd",
+		Channels:   int(binary.LittleEndian.Uint32(chunk[24:])),
+		SampleRate: int(binary.LittleEndian.Uint32(chunk[28:])),
+	}
+	samples := binary.LittleEndian.Uint64(chunk[36:])
+	if info.SampleRate > 0 {
+		info.DurationSec = float64(samples) / float64(info.SampleRate)
+	}
+	return info, nil
+}
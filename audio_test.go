@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mp3Header is MPEG-1 Layer III, 128 kbps, 44.1 kHz, stereo: 417-byte frames.
+var mp3Header = []byte{0xff, 0xfb, 0x90, 0x00}
+
+// mp3Frames builds count empty frames, the first holding firstBody after
+// its header.
+func mp3Frames(count int, firstBody []byte) []byte {
+	var data []byte
+	for i := 0; i < count; i++ {
+		frame := make([]byte, 417)
+		copy(frame, mp3Header)
+		if i == 0 {
+			copy(frame[4:], firstBody)
+		}
+		data = append(data, frame...)
+	}
+	return data
+}
+
+// flacStreamInfo builds a FLAC header whose STREAMINFO says 16-bit stereo at
+// sampleRate with totalSamples samples.
+func flacStreamInfo(sampleRate int, totalSamples uint32) []byte {
+	data := []byte("fLaC")
+	data = append(data, 0x80, 0, 0, 34)
+	info := make([]byte, 34)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	info[12] = byte(sampleRate<<4) | 1<<1
+	info[13] = 0xf0
+	binary.BigEndian.PutUint32(info[14:], totalSamples)
+	return append(data, info...)
+}
+
+func atom(name string, children ...[]byte) []byte {
+	var body []byte
+	for _, child := range children {
+		body = append(body, child...)
+	}
+	data := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	data = append(data, name...)
+	return append(data, body...)
+}
+
+// mp4Audio builds an M4A file with the moov atom after the media data, as
+// many encoders write it.
+func mp4Audio(codec string, sampleRate int, timescale, duration uint32) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], duration)
+
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[8:], 2)
+	binary.BigEndian.PutUint32(entry[16:], uint32(sampleRate)<<16)
+	stsd := append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, atom(codec, make([]byte, 8), entry)...)
+
+	data := atom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	data = append(data, atom("mdat", make([]byte, 4096))...)
+	return append(data, atom("moov", atom("mvhd", mvhd),
+		atom("trak", atom("mdia", atom("minf", atom("stbl", atom("stsd", stsd))))))...)
+}
+
+// oggPage builds an Ogg page holding one packet of under 255 bytes.
+func oggPage(granule uint64, packet []byte) []byte {
+	page := []byte("OggS\x00\x02")
+	page = binary.LittleEndian.AppendUint64(page, granule)
+	page = append(page, make([]byte, 12)...)
+	page = append(page, 1, byte(len(packet)))
+	return append(page, packet...)
+}
+
+func TestReadAudioInfo(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "audio_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	xing := make([]byte, 32+16)
+	copy(xing[32:], "Xing")
+	binary.BigEndian.PutUint32(xing[36:], 3)
+	binary.BigEndian.PutUint32(xing[40:], 1000)
+	binary.BigEndian.PutUint32(xing[44:], 500000)
+
+	wav := []byte("RIFF\x00\x00\x00\x00WAVEfmt \x10\x00\x00\x00")
+	wav = binary.LittleEndian.AppendUint16(wav, 1)
+	wav = binary.LittleEndian.AppendUint16(wav, 2)
+	wav = binary.LittleEndian.AppendUint32(wav, 44100)
+	wav = binary.LittleEndian.AppendUint32(wav, 176400)
+	wav = append(wav, 4, 0, 16, 0)
+	wav = append(wav, "data"...)
+	wav = binary.LittleEndian.AppendUint32(wav, 176400*3)
+
+	opusHead := append([]byte("OpusHead\x01\x02"), 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0)
+	opus := append(oggPage(0, opusHead), oggPage(48000*5+312, make([]byte, 100))...)
+
+	vorbisHead := append([]byte("\x01vorbis"), make([]byte, 23)...)
+	vorbisHead[11] = 2
+	binary.LittleEndian.PutUint32(vorbisHead[12:], 44100)
+	vorbis := append(oggPage(0, vorbisHead), oggPage(44100*4, make([]byte, 100))...)
+
+	aiff := []byte("FORM\x00\x00\x00\x00AIFFCOMM\x00\x00\x00\x12")
+	aiff = binary.BigEndian.AppendUint16(aiff, 2)
+	aiff = binary.BigEndian.AppendUint32(aiff, 44100*2)
+	aiff = binary.BigEndian.AppendUint16(aiff, 16)
+	aiff = append(aiff, 0x40, 0x0e, 0xac, 0x44, 0, 0, 0, 0, 0, 0)
+
+	dsf := append([]byte("DSD "), make([]byte, 24)...)
+	dsf = append(dsf, "fmt "...)
+	fmtChunk := make([]byte, 48)
+	binary.LittleEndian.PutUint32(fmtChunk[20:], 2)
+	binary.LittleEndian.PutUint32(fmtChunk[24:], 2822400)
+	binary.LittleEndian.PutUint64(fmtChunk[32:], 2822400*3)
+	dsf = append(dsf, fmtChunk...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		codec    string
+		duration float64
+		bitrate  int
+		rate     int
+	}{
+		// 100 frames of 417 bytes at 128 kbps, after an ID3v2 tag
+		{"cbr.mp3", append(id3v23(map[string]string{"TIT2": "Song"}), mp3Frames(100, nil)...), "mp3", 41700 * 8 / 128000.0, 128, 44100},
+		{"vbr.mp3", mp3Frames(10, xing), "mp3", 1000 * 1152 / 44100.0, 153, 44100},
+		{"lossless.flac", flacStreamInfo(44100, 441000), "flac", 10, 0, 44100},
+		{"aac.m4a", mp4Audio("mp4a", 48000, 1000, 180000), "aac", 180, 0, 48000},
+		{"apple.m4a", mp4Audio("alac", 44100, 44100, 441000), "alac", 10, 0, 44100},
+		{"pcm.wav", wav, "pcm", 3, 1411, 44100},
+		{"speech.opus", opus, "opus", 5, 0, 48000},
+		{"vorbis.ogg", vorbis, "vorbis", 4, 0, 44100},
+		{"pcm.aiff", aiff, "pcm", 2, 0, 44100},
+		{"dsd.dsf", dsf, "dsd", 3, 0, 2822400},
+		// Content wins over the extension
+		{"mislabelled.mp3", flacStreamInfo(44100, 441000), "flac", 10, 0, 44100},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(tempDir, test.name)
+		if err := os.WriteFile(path, test.data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", test.name, err)
+		}
+
+		info, err := readAudioInfo(path)
+		if err != nil {
+			t.Errorf("%s: failed to read audio info: %v", test.name, err)
+			continue
+		}
+		if info.Codec != test.codec || info.SampleRate != test.rate {
+			t.Errorf("%s: expected %s at %d Hz, got %+v", test.name, test.codec, test.rate, info)
+		}
+		if math.Abs(info.DurationSec-test.duration) > 0.01 {
+			t.Errorf("%s: expected %.2fs, got %.2fs", test.name, test.duration, info.DurationSec)
+		}
+		if test.bitrate != 0 && info.BitrateKbps != test.bitrate {
+			t.Errorf("%s: expected %d kbps, got %d", test.name, test.bitrate, info.BitrateKbps)
+		}
+	}
+
+	// Recognized without a duration
+	for name, data := range map[string][]byte{
+		"ape":  []byte("MAC \x96\x0f\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+		"wv":   []byte("wvpk\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+		"wma":  append(asfHeader, make([]byte, 8)...),
+		"aac":  append([]byte{0xff, 0xf1, 0x50, 0x80}, make([]byte, 12)...),
+		"alac": mp4Audio("alac", 44100, 44100, 441000),
+	} {
+		path := filepath.Join(tempDir, "track.bin")
+		os.WriteFile(path, data, 0644)
+		if info, err := readAudioInfo(path); err != nil || info.Format != name {
+			t.Errorf("Expected %s, got %+v, %v", name, info, err)
+		}
+	}
+
+	// Unknown content still reports the extension
+	path := filepath.Join(tempDir, "noise.ogg")
+	os.WriteFile(path, make([]byte, 64), 0644)
+	if info, err := readAudioInfo(path); err == nil || info.Codec != "ogg" {
+		t.Errorf("Expected an error and the extension as codec, got %+v, %v", info, err)
+	}
+}
+
+func TestAlbumTracksEndpoint(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "audio_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	albumPath := filepath.Join(tempDir, "Artist", "Album")
+	createAlbum(t, albumPath, []string{"cover.jpg"})
+	mp3 := append(id3v23(map[string]string{"TIT2": "Second", "TPE1": "Artist", "TRCK": "2/2"}), mp3Frames(100, nil)...)
+	if err := os.WriteFile(filepath.Join(albumPath, "a.mp3"), mp3, 0644); err != nil {
+		t.Fatalf("Failed to write mp3: %v", err)
+	}
+	flac := flacWithComments([]string{"TITLE=First", "TRACKNUMBER=1"})
+	if err := os.WriteFile(filepath.Join(albumPath, "b.flac"), flac, 0644); err != nil {
+		t.Fatalf("Failed to write flac: %v", err)
+	}
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+	server.lastScan = server.scanMusicFolders(tempDir)
+	if len(server.lastScan) != 1 {
+		t.Fatalf("Expected 1 album, got %d", len(server.lastScan))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/albums/"+server.lastScan[0].Fingerprint+"/tracks", nil)
+	w := httptest.NewRecorder()
+	server.handleAlbum(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var tracks []Track
+	if err := json.NewDecoder(w.Body).Decode(&tracks); err != nil {
+		t.Fatalf("Failed to decode tracks: %v", err)
+	}
+	if len(tracks) != 2 {
+		t.Fatalf("Expected 2 tracks, got %d", len(tracks))
+	}
+	// Ordered by track number, not file name
+	if tracks[0].Title != "First" || tracks[1].Title != "Second" || tracks[1].TrackNumber != 2 {
+		t.Errorf("Unexpected track order: %+v", tracks)
+	}
+	if tracks[1].Codec != "mp3" || tracks[1].BitrateKbps != 128 || tracks[1].Artist != "Artist" || tracks[1].SizeBytes != int64(len(mp3)) {
+		t.Errorf("Unexpected mp3 track: %+v", tracks[1])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/albums/unknown/tracks", nil)
+	w = httptest.NewRecorder()
+	server.handleAlbum(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown album, got %d", w.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	authCookieName = "music_sync_token"
+	authHeaderName = "X-Music-Sync-Token"
+)
+
+// generateToken returns a random hex secret. A new one is created on every
+// launch and handed to the browser through the URL that openBrowser opens.
+func generateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (s *Server) validToken(token string) bool {
+	if s.authToken == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.authToken)) == 1
+}
+
+// requestToken returns the token sent with an API request, either in the
+// header (scripts) or the cookie set when the UI was opened (browser).
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get(authHeaderName); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(authCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// isSameOrigin reports whether a browser request comes from the page served
+// by this server. Requests without an Origin header are not from a
+// cross-origin page and are left to the token check.
+func isSameOrigin(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(originURL.Host, r.Host)
+}
+
+func isStateChanging(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// requireAuth wraps the router. Opening the UI with ?token= stores the token
+// in a cookie; every /api/ call must then carry the token, and state-changing
+// calls must also come from our own origin.
+func (s *Server) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			if token := r.URL.Query().Get("token"); token != "" {
+				if !s.validToken(token) {
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:     authCookieName,
+					Value:    token,
+					Path:     "/",
+					HttpOnly: true,
+					SameSite: http.SameSiteStrictMode,
+				})
+				// Drop the token from the address bar and history
+				query := r.URL.Query()
+				query.Del("token")
+				redirect := r.URL.Path
+				if len(query) > 0 {
+					redirect += "?" + query.Encode()
+				}
+				http.Redirect(w, r, redirect, http.StatusFound)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if isStateChanging(r.Method) && !isSameOrigin(r) {
+			http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+			return
+		}
+
+		if !s.validToken(requestToken(r)) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAuth(t *testing.T) {
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		authToken:        "secret",
+	}
+
+	handler := server.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name     string
+		method   string
+		path     string
+		header   map[string]string
+		cookie   string
+		expected int
+	}{
+		{"no token", http.MethodGet, "/api/drives", nil, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/api/drives", map[string]string{authHeaderName: "guess"}, "", http.StatusUnauthorized},
+		{"header token", http.MethodGet, "/api/drives", map[string]string{authHeaderName: "secret"}, "", http.StatusOK},
+		{"cookie token", http.MethodPost, "/api/sync", map[string]string{"Origin": "http://localhost:8080"}, "secret", http.StatusOK},
+		{"cross-origin post", http.MethodPost, "/api/unsync", map[string]string{"Origin": "http://evil.example"}, "secret", http.StatusForbidden},
+		{"cross-site fetch", http.MethodPost, "/api/unsync", map[string]string{"Sec-Fetch-Site": "cross-site"}, "secret", http.StatusForbidden},
+		{"static files", http.MethodGet, "/", nil, "", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "http://localhost:8080"+c.path, nil)
+		for key, value := range c.header {
+			req.Header.Set(key, value)
+		}
+		if c.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: authCookieName, Value: c.cookie})
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.expected, rec.Code)
+		}
+	}
+}
+
+func TestRequireAuthSetsCookieFromURL(t *testing.T) {
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		authToken:        "secret",
+	}
+
+	handler := server.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/?token=secret", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected redirect, got %d", rec.Code)
+	}
+	if location := rec.Header().Get("Location"); location != "/" {
+		t.Errorf("Expected redirect to /, got %s", location)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != authCookieName || cookies[0].Value != "secret" {
+		t.Errorf("Expected auth cookie to be set, got %v", cookies)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// deviceWatchInterval is how often the mount table is polled for devices.
+const deviceWatchInterval = 5 * time.Second
+
+// autoSyncHistoryLimit caps how many auto-sync outcomes are kept.
+const autoSyncHistoryLimit = 50
+
+// AutoSyncRun records one automatic sync triggered by a device appearing.
+type AutoSyncRun struct {
+	Device     string      `json:"device"`
+	SyncSet    string      `json:"syncSet"`
+	MountPath  string      `json:"mountPath"`
+	DryRun     bool        `json:"dryRun"`
+	StartedAt  time.Time   `json:"startedAt"`
+	FinishedAt time.Time   `json:"finishedAt"`
+	Run        *SyncSetRun `json:"run,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// deviceWatcher remembers where each device was mounted at the last poll.
+type deviceWatcher struct {
+	present     map[string]string
+	initialized bool
+}
+
+// pollDevices checks which devices are mounted and auto-syncs the ones that
+// appeared since the last poll. Devices already present on the first poll do
+// not trigger a sync, so starting the app never writes to a device.
+// Auto-sync devices only count as present when their volume or marker is
+// found: a saved mount path alone may be a folder on the host's own disk.
+func (s *Server) pollDevices(watcher *deviceWatcher) []AutoSyncRun {
+	devices := s.loadSettings().Devices
+	current := make(map[string]string)
+	var runs []AutoSyncRun
+
+	for i, presence := range s.devicePresence(devices) {
+		if !presence.Present || (devices[i].AutoSync && presence.MatchedBy == "path") {
+			continue
+		}
+		current[presence.Name] = presence.MountPath
+		if _, seen := watcher.present[presence.Name]; seen || !watcher.initialized {
+			continue
+		}
+
+		s.publish("device-connected", presence)
+		if devices[i].AutoSync {
+			runs = append(runs, s.autoSync(devices[i], presence.MountPath))
+		}
+	}
+
+	for name := range watcher.present {
+		if _, ok := current[name]; !ok {
+			s.publish("device-disconnected", map[string]string{"name": name})
+		}
+	}
+
+	watcher.present = current
+	watcher.initialized = true
+	return runs
+}
+
+// autoSync runs device's default sync set against mountPath. Dry-run devices
+// only plan, as do mirror sets with albums missing (see runSavedSyncSet);
+// overwrites that need confirmation are not confirmed and show up as errors
+// in the run.
+func (s *Server) autoSync(device DeviceProfile, mountPath string) AutoSyncRun {
+	record := AutoSyncRun{
+		Device:    device.Name,
+		SyncSet:   device.DefaultSyncSet,
+		MountPath: mountPath,
+		DryRun:    device.AutoSyncDryRun,
+		StartedAt: time.Now(),
+	}
+	s.publish("auto-sync-started", record)
+
+	set, err := s.syncSet(device.DefaultSyncSet)
+	if err == nil {
+		var run SyncSetRun
+		run, err = s.runSavedSyncSet(set, mountPath, SyncOptions{DryRun: device.AutoSyncDryRun, Device: &device})
+		record.Run = &run
+		if run.Held != "" {
+			record.DryRun = true
+			log.Printf("Auto-sync of %s only planned: %s", device.Name, run.Held)
+			s.publish("auto-sync-held", map[string]string{"device": device.Name, "syncSet": set.Name, "reason": run.Held})
+		}
+	}
+	if err != nil {
+		record.Error = err.Error()
+		log.Printf("Auto-sync of %s failed: %v", device.Name, err)
+	}
+	record.FinishedAt = time.Now()
+
+	s.autoSyncMutex.Lock()
+	s.autoSyncRuns = append(s.autoSyncRuns, record)
+	if len(s.autoSyncRuns) > autoSyncHistoryLimit {
+		s.autoSyncRuns = s.autoSyncRuns[len(s.autoSyncRuns)-autoSyncHistoryLimit:]
+	}
+	s.autoSyncMutex.Unlock()
+
+	s.publish("auto-sync-finished", record)
+	return record
+}
+
+// watchDevices polls for devices until the process exits.
+func (s *Server) watchDevices(interval time.Duration) {
+	watcher := &deviceWatcher{}
+	for {
+		s.pollDevices(watcher)
+		time.Sleep(interval)
+	}
+}
+
+// handleAutoSync lists the recorded auto-sync outcomes, oldest first.
+func (s *Server) handleAutoSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.autoSyncMutex.Lock()
+	runs := append([]AutoSyncRun{}, s.autoSyncRuns...)
+	s.autoSyncMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
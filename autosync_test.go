@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAutoSyncOnDeviceMount(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "autosync_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	album := filepath.Join(tempDir, "library", "Artist", "Album")
+	stick := filepath.Join(tempDir, "media", "CAR")
+	createAlbum(t, album, []string{"01.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+		events:           newEventHub(),
+	}
+	if err := server.updateSettings(func(settings *AppSettings) error {
+		settings.LibraryRoots = []string{tempDir}
+		settings.TargetRoots = []string{filepath.Join(tempDir, "media")}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	if err := server.saveSyncSet(SyncSet{Name: "Car", Albums: []AlbumRef{{Path: album}}}, true); err != nil {
+		t.Fatalf("Failed to create sync set: %v", err)
+	}
+	if err := server.saveDevice(DeviceProfile{Name: "Car stick", MountPath: stick, DefaultSyncSet: "Car", AutoSync: true}, true); err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	if err := server.saveDevice(DeviceProfile{Name: "No set", MountPath: stick, AutoSync: true}, true); err == nil {
+		t.Error("Expected auto-sync without a default sync set to be rejected")
+	}
+
+	events := server.events.subscribe()
+	defer server.events.unsubscribe(events)
+
+	watcher := &deviceWatcher{}
+	if runs := server.pollDevices(watcher); len(runs) != 0 {
+		t.Fatalf("Expected no runs while the stick is absent, got %+v", runs)
+	}
+
+	// An empty mount point is not the stick, even at its saved path
+	createAlbum(t, stick, nil)
+	if runs := server.pollDevices(watcher); len(runs) != 0 {
+		t.Fatalf("Expected no runs into the empty mount point, got %+v", runs)
+	}
+
+	// The stick is plugged in and recognised by its marker
+	if err := writeDeviceMarker(stick, "Car stick"); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+	runs := server.pollDevices(watcher)
+	if len(runs) != 1 || runs[0].Error != "" || len(runs[0].Run.Results) != 1 {
+		t.Fatalf("Expected one successful auto-sync, got %+v", runs)
+	}
+	if _, err := os.Stat(filepath.Join(stick, "Album", "01.mp3")); err != nil {
+		t.Errorf("Expected the album on the stick: %v", err)
+	}
+
+	// Staying plugged in does not sync again
+	if runs := server.pollDevices(watcher); len(runs) != 0 {
+		t.Errorf("Expected no second run, got %+v", runs)
+	}
+	if len(server.autoSyncRuns) != 1 {
+		t.Errorf("Expected one recorded run, got %d", len(server.autoSyncRuns))
+	}
+
+	var types []string
+	for len(events) > 0 {
+		types = append(types, (<-events).Type)
+	}
+	expected := []string{"device-connected", "auto-sync-started", "auto-sync-finished"}
+	if len(types) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Errorf("Expected events %v, got %v", expected, types)
+			break
+		}
+	}
+}
+
+func TestAutoSyncHoldsMirrorWithMissingAlbums(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "autosync_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	synced := filepath.Join(tempDir, "library", "Air", "Moon Safari")
+	wanted := filepath.Join(tempDir, "library", "Beck", "Odelay")
+	stick := filepath.Join(tempDir, "media", "CAR")
+	createAlbum(t, synced, []string{"01.mp3"})
+	createAlbum(t, wanted, []string{"01.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+		events:           newEventHub(),
+	}
+	if err := server.updateSettings(func(settings *AppSettings) error {
+		settings.LibraryRoots = []string{tempDir}
+		settings.TargetRoots = []string{filepath.Join(tempDir, "media")}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	set := SyncSet{Name: "Car", Mirror: true, Albums: []AlbumRef{{Path: wanted}, {Path: filepath.Join(tempDir, "offline", "Album")}}}
+	if err := server.saveSyncSet(set, true); err != nil {
+		t.Fatalf("Failed to create sync set: %v", err)
+	}
+	if err := server.saveDevice(DeviceProfile{Name: "Car stick", MountPath: stick, DefaultSyncSet: "Car", AutoSync: true}, true); err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+
+	watcher := &deviceWatcher{}
+	server.pollDevices(watcher)
+
+	// The stick already holds an album music-sync put there
+	createAlbum(t, stick, nil)
+	if err := writeDeviceMarker(stick, "Car stick"); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+	if _, err := server.syncAlbum(synced, stick, SyncOptions{}); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	events := server.events.subscribe()
+	defer server.events.unsubscribe(events)
+
+	runs := server.pollDevices(watcher)
+	if len(runs) != 1 || runs[0].Error != "" || !runs[0].DryRun || runs[0].Run.Held == "" {
+		t.Fatalf("Expected the mirror to be held as a dry run, got %+v", runs)
+	}
+	if mirror := runs[0].Run.Mirror; mirror == nil || !mirror.DryRun || len(mirror.Plan.Remove) != 1 {
+		t.Errorf("Expected the plan to show the removal it held back, got %+v", mirror)
+	}
+	if _, err := os.Stat(filepath.Join(stick, "Moon Safari", "01.mp3")); err != nil {
+		t.Errorf("Expected the synced album to stay on the stick: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(stick, "Odelay")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing copied while held, got %v", err)
+	}
+
+	held := false
+	for len(events) > 0 {
+		if (<-events).Type == "auto-sync-held" {
+			held = true
+		}
+	}
+	if !held {
+		t.Errorf("Expected an auto-sync-held event")
+	}
+
+	// Mirroring nothing is refused outright
+	if _, err := server.mirror(nil, stick, SyncOptions{}); err != errEmptyMirror {
+		t.Errorf("Expected an empty mirror to be refused, got %v", err)
+	}
+}
@@ -11,24 +11,24 @@ mkdir -p dist
 
 # Build Go backend for current platform
 echo "🔧 Building Go backend..."
-go build -o dist/music-sync main.go
+go build -o dist/music-sync .
 
 # Build for Windows (if on non-Windows platform)
 if [[ "$OSTYPE" != "msys" && "$OSTYPE" != "cygwin" ]]; then
     echo "🪟 Building for Windows..."
-    GOOS=windows GOARCH=amd64 go build -o dist/music-sync.exe main.go
+    GOOS=windows GOARCH=amd64 go build -o dist/music-sync.exe .
 fi
 
 # Build for Linux (if on non-Linux platform)
 if [[ "$OSTYPE" != "linux-gnu"* ]]; then
     echo "🐧 Building for Linux..."
-    GOOS=linux GOARCH=amd64 go build -o dist/music-sync-linux main.go
+    GOOS=linux GOARCH=amd64 go build -o dist/music-sync-linux .
 fi
 
 # Build for macOS (if on non-macOS platform)
 if [[ "$OSTYPE" != "darwin"* ]]; then
     echo "🍎 Building for macOS..."
-    GOOS=darwin GOARCH=amd64 go build -o dist/music-sync-macos main.go
+    GOOS=darwin GOARCH=amd64 go build -o dist/music-sync-macos .
 fi
 
 echo "✅ Build complete!"
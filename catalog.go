@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// catalogVersion changes whenever catalogued albums would lack fields that
+// scanning now fills in, so older catalogs are rebuilt.
+const catalogVersion = 7
+
+// catalogDir is what the catalog remembers about one library directory. It
+// is reused as long as the directory's modification time is unchanged.
+type catalogDir struct {
+	ModTime time.Time     `json:"mod_time"`
+	Files   []catalogFile `json:"files"`
+	Subdirs []string      `json:"subdirs,omitempty"`
+	Album   *AlbumFolder  `json:"album,omitempty"`
+	Tracks  []Track       `json:"tracks,omitempty"`
+}
+
+type catalogFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type catalogData struct {
+	Version int                    `json:"version"`
+	Dirs    map[string]*catalogDir `json:"dirs"`
+}
+
+// libraryCatalog is the persistent record of scanned directories, albums
+// and tracks, stored as a single JSON file.
+type libraryCatalog struct {
+	file  string
+	mutex sync.RWMutex
+	dirs  map[string]*catalogDir
+}
+
+// loadCatalog reads the catalog in file. A missing or unreadable catalog
+// starts empty and is rebuilt by the next scan.
+func loadCatalog(file string) *libraryCatalog {
+	catalog := &libraryCatalog{file: file, dirs: make(map[string]*catalogDir)}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return catalog
+	}
+	var stored catalogData
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != catalogVersion {
+		log.Printf("Warning: Ignoring library catalog %s", file)
+		return catalog
+	}
+	if stored.Dirs != nil {
+		catalog.dirs = stored.Dirs
+	}
+	return catalog
+}
+
+func (c *libraryCatalog) save() error {
+	c.mutex.RLock()
+	data, err := json.Marshal(catalogData{Version: catalogVersion, Dirs: c.dirs})
+	c.mutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal catalog: %v", err)
+	}
+
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write catalog: %v", err)
+	}
+	if err := os.Rename(tmp, c.file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write catalog: %v", err)
+	}
+	return nil
+}
+
+// listing reads the directory and marks it as cached when neither it nor any
+// of its files changed size or modification time since the catalog recorded
+// it, so a file rewritten in place is read again. A nil catalog never
+// marks listings.
+func (c *libraryCatalog) listing(path string) *dirListing {
+	listing := readListing(path)
+	if c == nil || listing.err != nil {
+		return listing
+	}
+
+	c.mutex.RLock()
+	cached, ok := c.dirs[path]
+	c.mutex.RUnlock()
+	if !ok || !cached.ModTime.Equal(listing.modTime) || len(cached.Files) != len(listing.files) {
+		return listing
+	}
+	for i, file := range listing.files {
+		stored := cached.Files[i]
+		if stored.Name != file.name || stored.Size != file.size || !stored.ModTime.Equal(file.modTime) {
+			return listing
+		}
+	}
+
+	listing.cached = true
+	if cached.Album != nil {
+		album := *cached.Album
+		album.Tracks = cached.Tracks
+		listing.cachedAlbum = &album
+	}
+	return listing
+}
+
+// update records a completed scan of root, replacing the entries of
+// directories that changed and removing those that are gone. The file is
+// only written when an entry changed, so refreshing a folder that did not
+// change writes nothing.
+func (c *libraryCatalog) update(root string, tree *libraryTree, albums []AlbumFolder) {
+	if c == nil {
+		return
+	}
+
+	byPath := make(map[string]AlbumFolder, len(albums))
+	for _, album := range albums {
+		byPath[album.Path] = album
+	}
+
+	changed := false
+	c.mutex.Lock()
+	for path := range c.dirs {
+		if listing, ok := tree.listings[path]; isWithin(root, path) && (!ok || listing.err != nil) {
+			delete(c.dirs, path)
+			changed = true
+		}
+	}
+	for path, listing := range tree.listings {
+		if listing.err != nil {
+			continue
+		}
+		dir := &catalogDir{ModTime: listing.modTime, Files: []catalogFile{}}
+		for _, file := range listing.files {
+			dir.Files = append(dir.Files, catalogFile{Name: file.name, Size: file.size, ModTime: file.modTime})
+		}
+		for _, subdir := range listing.subdirs {
+			dir.Subdirs = append(dir.Subdirs, filepath.Base(subdir))
+		}
+		if album, ok := byPath[path]; ok {
+			dir.Album = &album
+			dir.Tracks = album.Tracks
+		}
+		if !sameCatalogDir(c.dirs[path], dir) {
+			c.dirs[path] = dir
+			changed = true
+		}
+	}
+	c.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	if err := c.save(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// sameCatalogDir reports whether two entries would be stored the same.
+func sameCatalogDir(a, b *catalogDir) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// albums returns the catalogued albums below root in walk order, without
+// touching the disk.
+func (c *libraryCatalog) albums(root string) []AlbumFolder {
+	albums := []AlbumFolder{}
+	if c == nil {
+		return albums
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var walk func(path string)
+	walk = func(path string) {
+		dir, ok := c.dirs[path]
+		if !ok {
+			return
+		}
+		if dir.Album != nil && path != root {
+			albums = append(albums, *dir.Album)
+		}
+		for _, subdir := range dir.Subdirs {
+			walk(filepath.Join(path, subdir))
+		}
+	}
+	walk(root)
+	return albums
+}
+
+// handleCatalog returns the catalogued albums below directory immediately,
+// as last scanned, so the library shows before a rescan finishes.
+func (s *Server) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	directory, err := resolveAllowed(r.URL.Query().Get("directory"), s.libraryRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.catalog.albums(directory))
+}
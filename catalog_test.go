@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCatalogIncrementalScan(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "catalog_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	first := filepath.Join(library, "Artist", "First")
+	second := filepath.Join(library, "Artist", "Second")
+	createAlbum(t, first, []string{"01.mp3", "02.mp3"})
+	createAlbum(t, second, []string{"01.flac"})
+
+	catalogFile := filepath.Join(tempDir, "catalog.json")
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		catalog:          loadCatalog(catalogFile),
+	}
+
+	albums := server.scanMusicFolders(library)
+	if len(albums) != 2 {
+		t.Fatalf("Expected 2 albums, got %d", len(albums))
+	}
+
+	// A fresh launch shows the library from the catalog without scanning
+	reloaded := loadCatalog(catalogFile)
+	catalogued := reloaded.albums(library)
+	if len(catalogued) != 2 || catalogued[0].Path != first || catalogued[1].Path != second {
+		t.Fatalf("Expected both albums from the catalog in walk order, got %+v", catalogued)
+	}
+	if catalogued[0].Fingerprint != albums[0].Fingerprint {
+		t.Errorf("Expected the catalogued fingerprint to match the scan")
+	}
+
+	if tracks := reloaded.dirs[first].Tracks; len(tracks) != 2 || tracks[0].Name != "01.mp3" || tracks[1].Name != "02.mp3" {
+		t.Fatalf("Expected the album's tracks in the catalog, got %+v", tracks)
+	}
+
+	// Mark the catalogued album and track so reuse is visible
+	reloaded.dirs[first].Album.Genre = "From Catalog"
+	reloaded.dirs[first].Tracks[0].Title = "From Catalog"
+	server = &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		catalog:          reloaded,
+	}
+
+	// Add an album and remove another; only changed folders are revisited
+	third := filepath.Join(library, "Artist", "Third")
+	createAlbum(t, third, []string{"01.ogg"})
+	if err := os.RemoveAll(second); err != nil {
+		t.Fatalf("Failed to remove album: %v", err)
+	}
+
+	albums = server.scanMusicFolders(library)
+	if len(albums) != 2 || albums[0].Path != first || albums[1].Path != third {
+		t.Fatalf("Expected First and Third after the rescan, got %+v", albums)
+	}
+	if albums[0].Genre != "From Catalog" {
+		t.Errorf("Expected the unchanged album to come from the catalog, got genre %q", albums[0].Genre)
+	}
+	if tracks := albums[0].Tracks; len(tracks) != 2 || tracks[0].Title != "From Catalog" {
+		t.Errorf("Expected the unchanged album's tracks to come from the catalog, got %+v", tracks)
+	}
+	if albums[1].Format != "ogg" {
+		t.Errorf("Expected the new album to be scanned, got format %q", albums[1].Format)
+	}
+	if _, ok := reloaded.dirs[second]; ok {
+		t.Errorf("Expected the removed album to leave the catalog")
+	}
+
+	// A rescan that finds nothing changed does not write the catalog
+	if err := os.Remove(catalogFile); err != nil {
+		t.Fatalf("Failed to remove catalog: %v", err)
+	}
+	server.scanMusicFolders(library)
+	if _, err := os.Stat(catalogFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the unchanged rescan to leave the catalog file alone, got %v", err)
+	}
+
+	// A track rewritten in place is read again, though its folder did not change
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(first, "01.mp3"), later, later); err != nil {
+		t.Fatalf("Failed to touch track: %v", err)
+	}
+	albums = server.scanMusicFolders(library)
+	if albums[0].Genre == "From Catalog" || albums[0].Tracks[0].Title == "From Catalog" {
+		t.Errorf("Expected the rewritten track's album to be rescanned, got %+v", albums[0])
+	}
+
+	// A folder whose contents change is rescanned
+	if err := os.WriteFile(filepath.Join(first, "03.mp3"), nil, 0644); err != nil {
+		t.Fatalf("Failed to add track: %v", err)
+	}
+	albums = server.scanMusicFolders(library)
+	if albums[0].Mp3Count != 3 || albums[0].Genre == "From Catalog" {
+		t.Errorf("Expected the changed album to be rescanned, got %+v", albums[0])
+	}
+}
+
+func TestCatalogIgnoresCorruptFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "catalog_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	catalogFile := filepath.Join(tempDir, "catalog.json")
+	if err := os.WriteFile(catalogFile, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("Failed to write catalog: %v", err)
+	}
+
+	catalog := loadCatalog(catalogFile)
+	if albums := catalog.albums(tempDir); len(albums) != 0 {
+		t.Errorf("Expected an empty catalog, got %d albums", len(albums))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+)
+
+// variousArtists is the album artist given to compilations without one.
+const variousArtists = "Various Artists"
+
+// variousNames are the names rippers and folder layouts use for "various
+// artists".
+var variousNames = []string{"various artists", "various", "va", "v.a.", "v/a", "compilations"}
+
+func isVariousArtists(name string) bool {
+	return containsString(variousNames, strings.ToLower(strings.TrimSpace(name)))
+}
+
+// featuringMarkers start the guest part of a track artist, which does not
+// make the track someone else's.
+var featuringMarkers = []string{" feat. ", " feat ", " ft. ", " featuring "}
+
+// primaryArtist is a track artist without its guests, for comparing tracks.
+func primaryArtist(artist string) string {
+	artist = strings.ToLower(strings.TrimSpace(artist))
+	for _, marker := range featuringMarkers {
+		if i := strings.Index(artist, marker); i != -1 {
+			artist = artist[:i]
+		}
+	}
+	return strings.TrimSpace(artist)
+}
+
+// trackArtists collects what an album's tracks say about who made it.
+type trackArtists struct {
+	compilationTag bool
+	albumArtist    string
+	artists        map[string]bool
+	tracks         int // tracks naming an artist
+}
+
+func (a *trackArtists) add(tags Tags) {
+	a.compilationTag = a.compilationTag || tags.Compilation
+	if a.albumArtist == "" {
+		a.albumArtist = strings.TrimSpace(tags.AlbumArtist)
+	}
+	if artist := primaryArtist(tags.Artist); artist != "" {
+		if a.artists == nil {
+			a.artists = make(map[string]bool)
+		}
+		a.artists[artist] = true
+		a.tracks++
+	}
+}
+
+// compilation decides whether the album is a compilation: its tracks are
+// tagged as one (TCMP or COMPILATION), its album artist or the artist its
+// folder names is "Various Artists", or most tracks are by different
+// artists. An album artist naming anyone else settles that it is not.
+func (a trackArtists) compilation(folderArtist string) bool {
+	switch {
+	case a.compilationTag || isVariousArtists(a.albumArtist):
+		return true
+	case a.albumArtist != "":
+		return false
+	case isVariousArtists(folderArtist):
+		return true
+	}
+	return len(a.artists) >= 2 && len(a.artists)*2 > a.tracks
+}
+
+// name is the album artist to report: the tagged one, or "Various Artists"
+// for a compilation without one.
+func (a trackArtists) name(compilation bool) string {
+	if compilation && (a.albumArtist == "" || isVariousArtists(a.albumArtist)) {
+		return variousArtists
+	}
+	return a.albumArtist
+}
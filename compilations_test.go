@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompilationDetection(t *testing.T) {
+	artists := func(tags ...Tags) trackArtists {
+		var a trackArtists
+		for _, tag := range tags {
+			a.add(tag)
+		}
+		return a
+	}
+
+	tests := []struct {
+		name         string
+		artists      trackArtists
+		folderArtist string
+		compilation  bool
+		albumArtist  string
+	}{
+		{"one artist", artists(Tags{Artist: "Air"}, Tags{Artist: "Air"}), "Air", false, ""},
+		{"guests", artists(Tags{Artist: "Air"}, Tags{Artist: "Air feat. Beck"}, Tags{Artist: "AIR ft. Françoise Hardy"}), "Air", false, ""},
+		{"tagged", artists(Tags{Artist: "Air", Compilation: true}), "Air", true, variousArtists},
+		{"various album artist", artists(Tags{Artist: "Air", AlbumArtist: "VA"}), "Air", true, variousArtists},
+		{"named album artist", artists(Tags{Artist: "Air", AlbumArtist: "DJ Kicks"}, Tags{Artist: "Beck"}), "DJ Kicks", false, "DJ Kicks"},
+		{"tagged with album artist", artists(Tags{Artist: "Air", AlbumArtist: "DJ Kicks", Compilation: true}), "Air", true, "DJ Kicks"},
+		{"various folder", artists(Tags{Artist: "Air"}), "Various", true, variousArtists},
+		{"mixed artists", artists(Tags{Artist: "Air"}, Tags{Artist: "Beck"}, Tags{Artist: "Moby"}), "Air", true, variousArtists},
+		{"mostly one artist", artists(Tags{Artist: "Air"}, Tags{Artist: "Air"}, Tags{Artist: "Air"}, Tags{Artist: "Beck"}), "Air", false, ""},
+	}
+	for _, test := range tests {
+		compilation := test.artists.compilation(test.folderArtist)
+		if compilation != test.compilation {
+			t.Errorf("%s: expected compilation %v, got %v", test.name, test.compilation, compilation)
+		}
+		if name := test.artists.name(compilation); name != test.albumArtist {
+			t.Errorf("%s: expected album artist %q, got %q", test.name, test.albumArtist, name)
+		}
+	}
+}
+
+func TestScanDetectsCompilations(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "compilations_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	mix := filepath.Join(library, "Various", "Summer Hits")
+	solo := filepath.Join(library, "Air", "Moon Safari")
+	createAlbum(t, mix, nil)
+	createAlbum(t, solo, nil)
+	write := func(path string, frames map[string]string) {
+		data := append(id3v23(frames), mp3Frames(2, nil)...)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	write(filepath.Join(mix, "01.mp3"), map[string]string{"TPE1": "Beck", "TCMP": "1"})
+	write(filepath.Join(mix, "02.mp3"), map[string]string{"TPE1": "Moby", "TCMP": "1"})
+	write(filepath.Join(solo, "01.mp3"), map[string]string{"TPE1": "Air"})
+	write(filepath.Join(solo, "02.mp3"), map[string]string{"TPE1": "Air feat. Beth Hirsch"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+	albums := server.scanMusicFolders(library)
+	if len(albums) != 2 {
+		t.Fatalf("Expected 2 albums, got %+v", albums)
+	}
+
+	byPath := make(map[string]AlbumFolder)
+	for _, album := range albums {
+		byPath[album.Path] = album
+	}
+	if album := byPath[mix]; !album.Compilation || album.Artist != variousArtists || album.AlbumArtist != variousArtists || album.Album != "Summer Hits" {
+		t.Errorf("Expected a Various Artists compilation, got %+v", album)
+	}
+	if album := byPath[solo]; album.Compilation || album.Artist != "Air" {
+		t.Errorf("Expected an album by Air, got %+v", album)
+	}
+
+	// Compilations follow their own layout template
+	device := DeviceProfile{LayoutTemplate: "{artist}/{album}", CompilationTemplate: "Compilations/{album}"}
+	if folder := device.albumFolder(byPath[mix]); folder != "Compilations/Summer Hits" {
+		t.Errorf("Expected the compilation under Compilations/, got %q", folder)
+	}
+	if folder := device.albumFolder(byPath[solo]); folder != "Air/Moon Safari" {
+		t.Errorf("Expected the album under its artist, got %q", folder)
+	}
+}
+
+func TestRenderLayout(t *testing.T) {
+	album := AlbumFolder{Name: "AC-DC - Back in Black", Artist: "AC/DC", Album: "Back in Black", Year: 1980}
+	tests := map[string]string{
+		"{artist}/{album}":           "AC-DC/Back in Black",
+		"{album_artist}/{year}":      "AC-DC/1980",
+		"{genre}/{artist}/{album}":   "AC-DC/Back in Black",
+		"{folder}":                   "AC-DC - Back in Black",
+		"Music/ {artist} /./{album}": "Music/AC-DC/Back in Black",
+	}
+	for template, expected := range tests {
+		if folder := renderLayout(template, album); folder != expected {
+			t.Errorf("Expected %q to render as %q, got %q", template, expected, folder)
+		}
+	}
+
+	if folder := (DeviceProfile{}).albumFolder(album); folder != album.Name {
+		t.Errorf("Expected the folder name without a template, got %q", folder)
+	}
+	if err := (DeviceProfile{Name: "x", MountPath: "/x", CompilationTemplate: "{label}/{album}"}).validate(); err == nil {
+		t.Errorf("Expected an unknown placeholder in the compilation template to be rejected")
+	}
+}
+
+func TestSyncUsesDeviceLayout(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "compilations_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	target := filepath.Join(tempDir, "player")
+	mix := filepath.Join(library, "Various", "Summer Hits")
+	createAlbum(t, mix, nil)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	for name, artist := range map[string]string{"01.mp3": "Beck", "02.mp3": "Moby"} {
+		data := append(id3v23(map[string]string{"TPE1": artist, "TCMP": "1"}), mp3Frames(2, nil)...)
+		if err := os.WriteFile(filepath.Join(mix, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+	}
+	if err := server.updateSettings(func(settings *AppSettings) error {
+		settings.TargetRoots = []string{tempDir}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	device := DeviceProfile{Name: "Player", MountPath: target, LayoutTemplate: "{artist}/{album}", CompilationTemplate: "Compilations/{album}"}
+	if err := server.saveDevice(device, true); err != nil {
+		t.Fatalf("Failed to save device: %v", err)
+	}
+	if err := writeDeviceMarker(target, "Player"); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+
+	options, err := server.syncOptions(SyncOptions{}, target)
+	if err != nil {
+		t.Fatalf("Failed to fill in options: %v", err)
+	}
+	if options.Device == nil || options.Device.Name != "Player" {
+		t.Fatalf("Expected the target's device in the options, got %+v", options.Device)
+	}
+	result, err := server.syncAlbum(mix, target, options)
+	if err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if result.Folder != "Compilations/Summer Hits" {
+		t.Errorf("Expected the compilation under Compilations/, got %q", result.Folder)
+	}
+	if _, err := os.Stat(filepath.Join(target, "Compilations", "Summer Hits", "01.mp3")); err != nil {
+		t.Errorf("Expected the tracks under the template path: %v", err)
+	}
+	if !server.checkSyncStatus(mix, target) {
+		t.Errorf("Expected the album to count as synced under its layout folder")
+	}
+}
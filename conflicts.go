@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Conflict policies, used when the target already has a folder with the
+// album's name that holds a different album.
+const (
+	conflictRename    = "rename"
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+)
+
+var errConfirmationRequired = errors.New("confirmation required")
+
+type SyncOptions struct {
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+	Confirm        bool   `json:"confirm,omitempty"`
+	DryRun         bool   `json:"dryRun,omitempty"`
+	// Device is the profile of the device being synced to, if any; its
+	// layout decides where albums go.
+	Device *DeviceProfile `json:"-"`
+}
+
+type NameConflict struct {
+	Folder         string `json:"folder"`
+	Managed        bool   `json:"managed"`
+	ExistingSource string `json:"existingSource,omitempty"`
+}
+
+type SyncResult struct {
+	Result   string        `json:"result"`
+	Folder   string        `json:"folder"`
+	Action   string        `json:"action"`
+	Conflict *NameConflict `json:"conflict,omitempty"`
+	DryRun   bool          `json:"dryRun,omitempty"`
+	Plan     *SyncPlan     `json:"plan,omitempty"`
+}
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case conflictRename, conflictSkip, conflictOverwrite:
+		return true
+	}
+	return false
+}
+
+// folderState classifies a candidate folder on the target for sourcePath: free
+// to use, already holding this album, or holding something else.
+func (s *Server) folderState(targetDirectory string, manifest *TargetManifest, folder, sourcePath, sourceFingerprint string) (ours bool, conflict *NameConflict) {
+	folderPath := filepath.Join(targetDirectory, folder)
+	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if entry, ok := manifest.find(folder); ok {
+		if entry.SourcePath == sourcePath {
+			return true, nil
+		}
+		// Same name and files from a source that has since moved
+		if _, err := os.Stat(entry.SourcePath); os.IsNotExist(err) && entry.Fingerprint == sourceFingerprint {
+			return true, nil
+		}
+		return false, &NameConflict{Folder: folder, Managed: true, ExistingSource: entry.SourcePath}
+	}
+
+	// Not recorded, but an exact copy of this album (synced before manifests)
+	if s.generateFolderFingerprint(folderPath) == sourceFingerprint {
+		return true, nil
+	}
+
+	return false, &NameConflict{Folder: folder}
+}
+
+// resolveTargetFolder picks the folder on the target that sourcePath should be
+// synced into, starting from folderName (see layoutFolder) and applying the
+// conflict policy when it is taken by a different album. The returned action
+// is "copy", "update", "rename", "skip" or "overwrite".
+func (s *Server) resolveTargetFolder(targetDirectory string, manifest *TargetManifest, sourcePath, folderName string, options SyncOptions) (string, string, *NameConflict, error) {
+	sourceFingerprint := s.generateFolderFingerprint(sourcePath)
+
+	// Already on the target, possibly under a renamed folder
+	if entry, ok := manifest.findBySource(sourcePath); ok {
+		if _, err := os.Stat(filepath.Join(targetDirectory, filepath.FromSlash(entry.Folder))); err == nil {
+			return filepath.FromSlash(entry.Folder), "update", nil, nil
+		}
+	}
+
+	ours, conflict := s.folderState(targetDirectory, manifest, folderName, sourcePath, sourceFingerprint)
+	if ours {
+		return folderName, "update", nil, nil
+	}
+	if conflict == nil {
+		return folderName, "copy", nil, nil
+	}
+
+	switch options.ConflictPolicy {
+	case conflictSkip:
+		return "", "skip", conflict, nil
+	case conflictOverwrite:
+		if !options.Confirm {
+			return "", "", conflict, errConfirmationRequired
+		}
+		return folderName, "overwrite", conflict, nil
+	}
+
+	// Rename: prefix the artist, then number until a free or matching folder
+	artist := s.libraryNaming(s.libraryRootOf(sourcePath)).parse(sourcePath).Artist
+	parent, name := filepath.Split(folderName)
+	prefixed, _ := options.filenameRules().cleanName(fmt.Sprintf("%s - %s", artist, name))
+	base := filepath.Join(parent, prefixed)
+	candidate := base
+	for i := 2; ; i++ {
+		ours, candidateConflict := s.folderState(targetDirectory, manifest, candidate, sourcePath, sourceFingerprint)
+		if ours {
+			return candidate, "update", conflict, nil
+		}
+		if candidateConflict == nil {
+			return candidate, "rename", conflict, nil
+		}
+		candidate = fmt.Sprintf("%s (%d)", base, i)
+	}
+}
+
+// layoutFolder is the folder sourcePath goes into on the target before any
+// conflict handling: where the device's layout templates put it, or else the
+// album's own folder name, cleaned up to suit the device's filename rules.
+// The rename is set when cleaning changed the name.
+func (s *Server) layoutFolder(sourcePath string, options SyncOptions) (string, *Rename) {
+	folder := filepath.Base(sourcePath)
+	device := options.Device
+	if device == nil {
+		return folder, nil
+	}
+	if device.LayoutTemplate != "" || device.CompilationTemplate != "" {
+		if album, ok := s.albumFolder(sourcePath); ok {
+			folder = device.albumFolder(album)
+		}
+	}
+
+	cleaned, reason := device.FilenameRules.cleanPath(filepath.ToSlash(folder))
+	if cleaned == filepath.ToSlash(folder) {
+		return filepath.FromSlash(folder), nil
+	}
+	return filepath.FromSlash(cleaned), &Rename{From: filepath.ToSlash(folder), To: cleaned, Reason: reason}
+}
+
+// filenameRules are the rules names on the target have to follow.
+func (options SyncOptions) filenameRules() FilenameRules {
+	if options.Device == nil {
+		return FilenameRules{}
+	}
+	return options.Device.FilenameRules
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncRenamesConflictingAlbum(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "conflict_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Two different albums with the same folder name and track names
+	files := []string{"01.mp3", "02.mp3"}
+	first := filepath.Join(tempDir, "source", "Queen", "Greatest Hits")
+	second := filepath.Join(tempDir, "source", "ABBA", "Greatest Hits")
+	targetDir := filepath.Join(tempDir, "target")
+	createAlbum(t, first, files)
+	createAlbum(t, second, files)
+	createAlbum(t, targetDir, nil)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	result, err := server.syncAlbum(first, targetDir, SyncOptions{ConflictPolicy: conflictRename})
+	if err != nil {
+		t.Fatalf("First sync failed: %v", err)
+	}
+	if result.Folder != "Greatest Hits" || result.Action != "copy" {
+		t.Errorf("Expected plain copy, got %+v", result)
+	}
+
+	// The second must not be merged into the first's folder
+	if server.checkSyncStatus(second, targetDir) {
+		t.Error("Expected second album not to be reported as synced")
+	}
+
+	result, err = server.syncAlbum(second, targetDir, SyncOptions{ConflictPolicy: conflictRename})
+	if err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if result.Folder != "ABBA - Greatest Hits" || result.Action != "rename" || result.Conflict == nil {
+		t.Errorf("Expected rename with artist prefix, got %+v", result)
+	}
+
+	if !server.checkSyncStatus(first, targetDir) {
+		t.Error("Expected first album to be reported as synced")
+	}
+	if !server.checkSyncStatus(second, targetDir) {
+		t.Error("Expected renamed album to be reported as synced")
+	}
+
+	// Syncing again updates the renamed folder instead of creating another
+	result, err = server.syncAlbum(second, targetDir, SyncOptions{ConflictPolicy: conflictRename})
+	if err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	if result.Folder != "ABBA - Greatest Hits" || result.Action != "update" {
+		t.Errorf("Expected update of renamed folder, got %+v", result)
+	}
+}
+
+func TestSyncConflictSkipAndOverwrite(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "conflict_policy_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sourceAlbum := filepath.Join(tempDir, "source", "Artist", "Album")
+	targetDir := filepath.Join(tempDir, "target")
+	createAlbum(t, sourceAlbum, []string{"01.mp3"})
+	// Copied by hand, with different content
+	createAlbum(t, filepath.Join(targetDir, "Album"), []string{"other.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	result, err := server.syncAlbum(sourceAlbum, targetDir, SyncOptions{ConflictPolicy: conflictSkip})
+	if err != nil {
+		t.Fatalf("Skip failed: %v", err)
+	}
+	if result.Action != "skip" || result.Conflict == nil || result.Conflict.Managed {
+		t.Errorf("Expected skip of unmanaged folder, got %+v", result)
+	}
+
+	_, err = server.syncAlbum(sourceAlbum, targetDir, SyncOptions{ConflictPolicy: conflictOverwrite})
+	if !errors.Is(err, errConfirmationRequired) {
+		t.Fatalf("Expected overwrite to need confirmation, got %v", err)
+	}
+
+	result, err = server.syncAlbum(sourceAlbum, targetDir, SyncOptions{ConflictPolicy: conflictOverwrite, Confirm: true})
+	if err != nil {
+		t.Fatalf("Overwrite failed: %v", err)
+	}
+	if result.Action != "overwrite" {
+		t.Errorf("Expected overwrite, got %+v", result)
+	}
+
+	// The replaced folder is in the trash, not merged into the new copy
+	if _, err := os.Stat(filepath.Join(targetDir, "Album", "other.mp3")); !os.IsNotExist(err) {
+		t.Error("Expected old files not to be merged into the synced album")
+	}
+	manifest, err := loadManifest(targetDir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(manifest.Trash) != 1 {
+		t.Errorf("Expected replaced folder in trash, got %+v", manifest.Trash)
+	}
+}
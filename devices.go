@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilenameRules describe what names a device can store.
+type FilenameRules struct {
+	// FATSafe replaces characters FAT32/exFAT cannot store.
+	FATSafe     bool   `json:"fatSafe,omitempty"`
+	ASCIIOnly   bool   `json:"asciiOnly,omitempty"`
+	MaxLength   int    `json:"maxLength,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// TranscodeProfile describes the format a device wants its music in. It is
+// stored with the device so players that need it can say so; syncs do not
+// convert files yet and copy them as they are.
+type TranscodeProfile struct {
+	Format      string `json:"format,omitempty"`
+	BitrateKbps int    `json:"bitrateKbps,omitempty"`
+}
+
+// DeviceProfile is a named player with its own sync configuration. The
+// device is found by volume UUID or label, a marker file in its root, or its
+// last known MountPath (see locateDevice).
+type DeviceProfile struct {
+	Name           string `json:"name"`
+	MountPath      string `json:"mountPath,omitempty"`
+	VolumeLabel    string `json:"volumeLabel,omitempty"`
+	VolumeUUID     string `json:"volumeUUID,omitempty"`
+	LayoutTemplate string `json:"layoutTemplate,omitempty"`
+	// CompilationTemplate lays out compilations instead of LayoutTemplate,
+	// e.g. "Compilations/{album}".
+	CompilationTemplate string           `json:"compilationTemplate,omitempty"`
+	FilenameRules       FilenameRules    `json:"filenameRules"`
+	Transcode           TranscodeProfile `json:"transcode"`
+	// CapacityReserveMB is space syncs leave free on the device.
+	CapacityReserveMB float64 `json:"capacityReserveMB,omitempty"`
+	DefaultSyncSet    string  `json:"defaultSyncSet,omitempty"`
+	// AutoSync runs the default sync set when the device is plugged in;
+	// with AutoSyncDryRun it is only planned.
+	AutoSync       bool      `json:"autoSync,omitempty"`
+	AutoSyncDryRun bool      `json:"autoSyncDryRun,omitempty"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+var errDeviceNotFound = errors.New("device not found")
+
+// layoutPlaceholders are the fields a layout template may use, e.g.
+// "{artist}/{album}" or "{genre}/{artist} - {album} ({year})".
+var layoutPlaceholders = []string{"artist", "album", "album_artist", "folder", "genre", "year"}
+
+var placeholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+var transcodeFormats = []string{"mp3", "aac", "ogg", "opus", "flac"}
+
+func validLayoutTemplate(template string) error {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if !containsString(layoutPlaceholders, match[1]) {
+			return fmt.Errorf("unknown layout placeholder {%s}", match[1])
+		}
+	}
+	if strings.ContainsAny(placeholderPattern.ReplaceAllString(template, ""), "{}") {
+		return fmt.Errorf("unbalanced braces in layout template %q", template)
+	}
+	if strings.HasPrefix(template, "/") || strings.Contains(template, "..") {
+		return fmt.Errorf("layout template %q must stay inside the device", template)
+	}
+	return nil
+}
+
+// renderLayout fills in template for album. Slashes inside a value do not
+// start new folders, and folders left empty by a missing value are dropped.
+func renderLayout(template string, album AlbumFolder) string {
+	values := map[string]string{
+		"artist":       album.Artist,
+		"album":        album.Album,
+		"album_artist": album.AlbumArtist,
+		"folder":       album.Name,
+		"genre":        album.Genre,
+	}
+	if values["album_artist"] == "" {
+		values["album_artist"] = album.Artist
+	}
+	if album.Year != 0 {
+		values["year"] = strconv.Itoa(album.Year)
+	}
+
+	rendered := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value := values[strings.Trim(placeholder, "{}")]
+		return strings.NewReplacer("/", "-", "\\", "-").Replace(value)
+	})
+
+	var parts []string
+	for _, part := range strings.Split(rendered, "/") {
+		if part = strings.TrimSpace(part); part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// albumFolder is where album goes on the device, relative to its root:
+// compilations follow CompilationTemplate when there is one, other albums
+// LayoutTemplate, and without a template the album keeps its folder name.
+func (device DeviceProfile) albumFolder(album AlbumFolder) string {
+	template := device.LayoutTemplate
+	if album.Compilation && device.CompilationTemplate != "" {
+		template = device.CompilationTemplate
+	}
+	if folder := renderLayout(template, album); folder != "" {
+		return folder
+	}
+	return album.Name
+}
+
+func (device DeviceProfile) validate() error {
+	if strings.TrimSpace(device.Name) == "" {
+		return errors.New("device name required")
+	}
+	if strings.ContainsAny(device.Name, "/\\") {
+		return errors.New("device name cannot contain slashes")
+	}
+	if device.MountPath == "" && device.VolumeLabel == "" && device.VolumeUUID == "" {
+		return errors.New("device needs a mount path, volume label or UUID")
+	}
+	if err := validLayoutTemplate(device.LayoutTemplate); err != nil {
+		return err
+	}
+	if err := validLayoutTemplate(device.CompilationTemplate); err != nil {
+		return err
+	}
+	if device.FilenameRules.MaxLength < 0 {
+		return errors.New("maximum filename length cannot be negative")
+	}
+	if strings.ContainsAny(device.FilenameRules.Replacement, fatInvalidChars+"/\\") {
+		return fmt.Errorf("replacement %q is not a valid filename character", device.FilenameRules.Replacement)
+	}
+	if device.Transcode.Format != "" && !containsString(transcodeFormats, device.Transcode.Format) {
+		return fmt.Errorf("unknown transcode format %q", device.Transcode.Format)
+	}
+	if device.Transcode.BitrateKbps < 0 || device.CapacityReserveMB < 0 {
+		return errors.New("bitrate and capacity reserve cannot be negative")
+	}
+	if device.AutoSync && device.DefaultSyncSet == "" {
+		return errors.New("auto-sync needs a default sync set")
+	}
+	return nil
+}
+
+func findDevice(devices []DeviceProfile, name string) int {
+	for i, device := range devices {
+		if device.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) device(name string) (DeviceProfile, error) {
+	devices := s.loadSettings().Devices
+	if i := findDevice(devices, name); i != -1 {
+		return devices[i], nil
+	}
+	return DeviceProfile{}, errDeviceNotFound
+}
+
+// targetDevice is the connected device targetDirectory is on, preferring the
+// active device when mounts are nested, or nil when it is on none.
+func (s *Server) targetDevice(targetDirectory string) *DeviceProfile {
+	settings := s.loadSettings()
+	var device *DeviceProfile
+	mountPath := ""
+	for i, presence := range s.devicePresence(settings.Devices) {
+		if !presence.Present || !isWithin(presence.MountPath, targetDirectory) {
+			continue
+		}
+		deeper := len(presence.MountPath) > len(mountPath)
+		if deeper || (len(presence.MountPath) == len(mountPath) && presence.Name == settings.ActiveDevice) {
+			device, mountPath = &settings.Devices[i], presence.MountPath
+		}
+	}
+	return device
+}
+
+// saveDevice stores device, creating it or replacing the one with the same
+// name. With create set, an existing device of that name is an error.
+func (s *Server) saveDevice(device DeviceProfile, create bool) error {
+	if err := device.validate(); err != nil {
+		return err
+	}
+	device.UpdatedAt = time.Now()
+
+	return s.updateSettings(func(settings *AppSettings) error {
+		if device.DefaultSyncSet != "" && findSyncSet(settings.SyncSets, device.DefaultSyncSet) == -1 {
+			return fmt.Errorf("sync set %q does not exist", device.DefaultSyncSet)
+		}
+		i := findDevice(settings.Devices, device.Name)
+		if i == -1 {
+			if !create {
+				return errDeviceNotFound
+			}
+			settings.Devices = append(settings.Devices, device)
+			return nil
+		}
+		if create {
+			return fmt.Errorf("device %q already exists", device.Name)
+		}
+		settings.Devices[i] = device
+		return nil
+	})
+}
+
+func (s *Server) deleteDevice(name string) error {
+	return s.updateSettings(func(settings *AppSettings) error {
+		i := findDevice(settings.Devices, name)
+		if i == -1 {
+			return errDeviceNotFound
+		}
+		settings.Devices = append(settings.Devices[:i], settings.Devices[i+1:]...)
+		if settings.ActiveDevice == name {
+			settings.ActiveDevice = ""
+		}
+		return nil
+	})
+}
+
+// selectDevice makes name the active device. Where it is currently mounted
+// becomes the target directory the UI starts from.
+func (s *Server) selectDevice(name string) (DevicePresence, error) {
+	device, err := s.device(name)
+	if err != nil {
+		return DevicePresence{}, err
+	}
+	presence := s.devicePresence([]DeviceProfile{device})[0]
+
+	err = s.updateSettings(func(settings *AppSettings) error {
+		if findDevice(settings.Devices, name) == -1 {
+			return errDeviceNotFound
+		}
+		settings.ActiveDevice = name
+		if presence.Present {
+			settings.LastTargetDirectory = presence.MountPath
+		}
+		return nil
+	})
+	return presence, err
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		settings := s.loadSettings()
+		devices := settings.Devices
+		if devices == nil {
+			devices = []DeviceProfile{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"devices": devices,
+			"active":  settings.ActiveDevice,
+			"present": s.devicePresence(devices),
+		})
+	case http.MethodPost:
+		var device DeviceProfile
+		if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.saveDevice(device, true); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "created"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDevice serves /api/devices/{name}, /api/devices/{name}/select,
+// /api/devices/{name}/marker and /api/devices/{name}/layout.
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/devices/")
+	if layoutName, ok := strings.CutSuffix(name, "/layout"); ok {
+		s.handleDeviceLayout(w, r, layoutName)
+		return
+	}
+	if selectName, ok := strings.CutSuffix(name, "/select"); ok {
+		s.handleSelectDevice(w, r, selectName)
+		return
+	}
+	if markerName, ok := strings.CutSuffix(name, "/marker"); ok {
+		s.handleDeviceMarker(w, r, markerName)
+		return
+	}
+	if name == "" {
+		http.Error(w, "Device name required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		device, err := s.device(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(device)
+	case http.MethodPut:
+		var device DeviceProfile
+		if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		device.Name = name
+		if err := s.saveDevice(device, false); err != nil {
+			status := http.StatusBadRequest
+			if err == errDeviceNotFound {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "saved"})
+	case http.MethodDelete:
+		if err := s.deleteDevice(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSelectDevice(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	presence, err := s.selectDevice(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presence)
+}
+
+// handleDeviceMarker writes a marker naming the device to the root of
+// mountPath, or of wherever the device is currently found.
+func (s *Server) handleDeviceMarker(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		MountPath string `json:"mountPath"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	device, err := s.device(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if req.MountPath == "" {
+		presence := s.devicePresence([]DeviceProfile{device})[0]
+		if !presence.Present {
+			http.Error(w, "Device is not connected; give its mountPath", http.StatusBadRequest)
+			return
+		}
+		req.MountPath = presence.MountPath
+	}
+
+	mountPath, err := resolveAllowed(req.MountPath, s.targetRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	if err := writeDeviceMarker(mountPath, device.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "marked", "mountPath": mountPath})
+}
+
+// LayoutPreview is where an album of the last scan would go on a device.
+type LayoutPreview struct {
+	Path        string `json:"path"`
+	Folder      string `json:"folder"`
+	Compilation bool   `json:"compilation"`
+}
+
+// handleDeviceLayout previews the device's layout templates against the
+// albums of the last scan.
+func (s *Server) handleDeviceLayout(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	device, err := s.device(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.lastScanMutex.RLock()
+	previews := make([]LayoutPreview, 0, len(s.lastScan))
+	for _, album := range s.lastScan {
+		previews = append(previews, LayoutPreview{
+			Path:        album.Path,
+			Folder:      device.albumFolder(album),
+			Compilation: album.Compilation,
+		})
+	}
+	s.lastScanMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(previews)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeviceProfiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "devices_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+	}
+
+	if err := server.saveSyncSet(SyncSet{Name: "Gym"}, true); err != nil {
+		t.Fatalf("Failed to create sync set: %v", err)
+	}
+
+	player := DeviceProfile{
+		Name:           "Anna's player",
+		MountPath:      "/media/anna",
+		LayoutTemplate: "{artist}/{year} - {album}",
+		FilenameRules:  FilenameRules{FATSafe: true, MaxLength: 64},
+		Transcode:      TranscodeProfile{Format: "aac", BitrateKbps: 256},
+		DefaultSyncSet: "Gym",
+	}
+	if err := server.saveDevice(player, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := server.saveDevice(player, true); err == nil {
+		t.Error("Expected creating a duplicate device to fail")
+	}
+	if device, _ := server.device("Anna's player"); device.Transcode != player.Transcode {
+		t.Errorf("Expected the transcoding profile to be stored, got %+v", device.Transcode)
+	}
+
+	invalid := []DeviceProfile{
+		{Name: "No location"},
+		{Name: "Bad layout", MountPath: "/media/x", LayoutTemplate: "{composer}/{album}"},
+		{Name: "Unbalanced", MountPath: "/media/x", LayoutTemplate: "{artist/{album}"},
+		{Name: "Escapes", MountPath: "/media/x", LayoutTemplate: "../{album}"},
+		{Name: "Negative reserve", VolumeLabel: "X", CapacityReserveMB: -1},
+		{Name: "Bad format", VolumeLabel: "X", Transcode: TranscodeProfile{Format: "wma"}},
+		{Name: "Negative bitrate", VolumeLabel: "X", Transcode: TranscodeProfile{BitrateKbps: -128}},
+		{Name: "Bad set", VolumeUUID: "1234", DefaultSyncSet: "Missing"},
+	}
+	for _, device := range invalid {
+		if err := server.saveDevice(device, true); err == nil {
+			t.Errorf("Expected %q to be rejected", device.Name)
+		}
+	}
+
+	// The player is not connected, so the last target is left alone
+	presence, err := server.selectDevice("Anna's player")
+	if err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	settings := server.loadSettings()
+	if presence.Present || settings.ActiveDevice != "Anna's player" || settings.LastTargetDirectory != "" {
+		t.Errorf("Expected the absent device to be active, got %+v and %+v", presence, settings)
+	}
+
+	// Deleting the default sync set clears it from the device
+	if err := server.deleteSyncSet("Gym"); err != nil {
+		t.Fatalf("Failed to delete sync set: %v", err)
+	}
+	if device, _ := server.device("Anna's player"); device.DefaultSyncSet != "" {
+		t.Errorf("Expected the default sync set to be cleared, got %q", device.DefaultSyncSet)
+	}
+
+	if err := server.deleteDevice("Anna's player"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if settings := server.loadSettings(); len(settings.Devices) != 0 || settings.ActiveDevice != "" {
+		t.Errorf("Expected no devices and no active device, got %+v", settings)
+	}
+}
+
+func TestSyncKeepsCapacityReserve(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "devices_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sourceAlbum := filepath.Join(tempDir, "library", "Air", "Moon Safari")
+	targetDir := filepath.Join(tempDir, "player")
+	createAlbum(t, sourceAlbum, nil)
+	createAlbum(t, targetDir, nil)
+	if err := os.WriteFile(filepath.Join(sourceAlbum, "01.mp3"), mp3Frames(2, nil), 0644); err != nil {
+		t.Fatalf("Failed to write track: %v", err)
+	}
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+	_, free, err := diskSpace(targetDir)
+	if err != nil {
+		t.Skipf("Free space unavailable: %v", err)
+	}
+	device := &DeviceProfile{Name: "Player", MountPath: targetDir, CapacityReserveMB: float64(free)/1024/1024 + 1}
+
+	for _, dryRun := range []bool{true, false} {
+		_, err := server.syncAlbum(sourceAlbum, targetDir, SyncOptions{Device: device, DryRun: dryRun})
+		if !errors.Is(err, errInsufficientSpace) {
+			t.Errorf("Expected the reserve to stop the sync (dry run %v), got %v", dryRun, err)
+		}
+	}
+	if _, err := server.mirror([]string{sourceAlbum}, targetDir, SyncOptions{Device: device}); !errors.Is(err, errInsufficientSpace) {
+		t.Errorf("Expected the reserve to stop the mirror, got %v", err)
+	}
+	if entries, _ := os.ReadDir(targetDir); len(entries) != 0 {
+		t.Errorf("Expected nothing written, got %d entries", len(entries))
+	}
+
+	// Running a mirror set into the full device says so rather than blaming
+	// the path
+	server.settingsFile = filepath.Join(tempDir, "settings.json")
+	if err := server.updateSettings(func(settings *AppSettings) error {
+		settings.LibraryRoots = []string{filepath.Join(tempDir, "library")}
+		settings.TargetRoots = []string{tempDir}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	if err := server.saveSyncSet(SyncSet{Name: "All", Mirror: true, Albums: []AlbumRef{{Path: sourceAlbum}}}, true); err != nil {
+		t.Fatalf("Failed to create sync set: %v", err)
+	}
+	if err := server.saveDevice(*device, true); err != nil {
+		t.Fatalf("Failed to save device: %v", err)
+	}
+	if err := writeDeviceMarker(targetDir, "Player"); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+	body := strings.NewReader(`{"targetDirectory": "` + targetDir + `"}`)
+	w := httptest.NewRecorder()
+	server.handleRunSyncSet(w, httptest.NewRequest(http.MethodPost, "/api/sync-sets/All/run", body), "All")
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("Expected 507 from the sync set run, got %d: %s", w.Code, w.Body.String())
+	}
+
+	device.CapacityReserveMB = 0
+	if _, err := server.syncAlbum(sourceAlbum, targetDir, SyncOptions{Device: device}); err != nil {
+		t.Errorf("Expected the album to fit without a reserve: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// discFolderPattern matches disc subfolders such as "CD1", "CD 2", "Disc 02",
+// "disk-1" or "Disc 1 - Live".
+var discFolderPattern = regexp.MustCompile(`(?i)^(?:cd|disc|disk)\s*[-_.]?\s*(\d{1,2})(?:\D.*)?$`)
+
+// AlbumDisc is one disc of a multi-disc album. Folder is the disc's subfolder
+// when it has one.
+type AlbumDisc struct {
+	Number     int    `json:"number"`
+	Folder     string `json:"folder,omitempty"`
+	TrackCount int    `json:"track_count"`
+}
+
+// discNumber reports whether folderName names a disc subfolder, and which.
+func discNumber(folderName string) (int, bool) {
+	match := discFolderPattern.FindStringSubmatch(folderName)
+	if match == nil {
+		return 0, false
+	}
+	n, _ := strconv.Atoi(match[1])
+	return n, true
+}
+
+// discListings picks the disc folders out of an album folder's subfolders.
+func discListings(subdirs []*dirListing) []*dirListing {
+	var discs []*dirListing
+	for _, subdir := range subdirs {
+		if subdir == nil || subdir.err != nil {
+			continue
+		}
+		if _, ok := discNumber(filepath.Base(subdir.path)); ok {
+			discs = append(discs, subdir)
+		}
+	}
+	return discs
+}
+
+// albumDiscs groups an album's tracks into discs, keyed by disc number and
+// subfolder. A single disc without a subfolder is not worth reporting.
+func albumDiscs(tracks map[AlbumDisc]int) []AlbumDisc {
+	var discs []AlbumDisc
+	numbers := make(map[int]bool)
+	hasFolder := false
+	for disc, count := range tracks {
+		disc.TrackCount = count
+		discs = append(discs, disc)
+		numbers[disc.Number] = true
+		hasFolder = hasFolder || disc.Folder != ""
+	}
+	if len(numbers) < 2 && !hasFolder {
+		return nil
+	}
+
+	sort.Slice(discs, func(i, j int) bool {
+		if discs[i].Number != discs[j].Number {
+			return discs[i].Number < discs[j].Number
+		}
+		return discs[i].Folder < discs[j].Folder
+	})
+	return discs
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscNumber(t *testing.T) {
+	tests := map[string]int{
+		"CD1":           1,
+		"cd 2":          2,
+		"Disc 02":       2,
+		"disk-3":        3,
+		"Disc 1 - Live": 1,
+		"CD2 (Bonus)":   2,
+	}
+	for name, expected := range tests {
+		if n, ok := discNumber(name); !ok || n != expected {
+			t.Errorf("Expected %q to be disc %d, got %d, %v", name, expected, n, ok)
+		}
+	}
+	for _, name := range []string{"CDs", "Discography", "CD123", "Abbey Road", "Disco 1"} {
+		if _, ok := discNumber(name); ok {
+			t.Errorf("Expected %q not to be a disc folder", name)
+		}
+	}
+}
+
+func TestScanMergesDiscFolders(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "discs_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	albumPath := filepath.Join(library, "Artist", "Album")
+	createAlbum(t, albumPath, []string{"cover.jpg"})
+	createAlbum(t, filepath.Join(albumPath, "CD1"), []string{"01.mp3", "02.mp3"})
+	createAlbum(t, filepath.Join(albumPath, "Disc 2"), []string{"01.mp3"})
+
+	// A flat album whose tags say which disc each track is on
+	flat := filepath.Join(library, "Artist", "Flat")
+	createAlbum(t, flat, nil)
+	for name, disc := range map[string]string{"1-01.mp3": "1/2", "1-02.mp3": "1/2", "2-01.mp3": "2/2"} {
+		data := append(id3v23(map[string]string{"TPOS": disc}), mp3Frames(2, nil)...)
+		if err := os.WriteFile(filepath.Join(flat, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+	albums := server.scanMusicFolders(library)
+	if len(albums) != 2 {
+		t.Fatalf("Expected the discs merged into 2 albums, got %+v", albums)
+	}
+
+	album := albums[0]
+	if album.Path != albumPath || album.Artist != "Artist" || album.Album != "Album" || album.TrackCount != 3 || !album.HasCover {
+		t.Errorf("Unexpected multi-disc album: %+v", album)
+	}
+	expected := []AlbumDisc{{Number: 1, Folder: "CD1", TrackCount: 2}, {Number: 2, Folder: "Disc 2", TrackCount: 1}}
+	if !reflect.DeepEqual(album.Discs, expected) {
+		t.Errorf("Expected discs %+v, got %+v", expected, album.Discs)
+	}
+	expected = []AlbumDisc{{Number: 1, TrackCount: 2}, {Number: 2, TrackCount: 1}}
+	if !reflect.DeepEqual(albums[1].Discs, expected) {
+		t.Errorf("Expected discs from tags %+v, got %+v", expected, albums[1].Discs)
+	}
+
+	// The fingerprint covers every disc, however it is computed
+	fresh := &Server{fingerprintCache: make(map[string]string)}
+	if fingerprint := fresh.generateFolderFingerprint(albumPath); fingerprint != album.Fingerprint {
+		t.Errorf("Expected the scanned fingerprint %s, got %s", album.Fingerprint, fingerprint)
+	}
+
+	// The album syncs as one unit, discs included
+	target := filepath.Join(tempDir, "target")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	if _, err := server.syncAlbum(albumPath, target, SyncOptions{ConflictPolicy: conflictRename}); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "Album", "Disc 2", "01.mp3")); err != nil {
+		t.Errorf("Expected the second disc on the target: %v", err)
+	}
+	if !server.checkSyncStatus(albumPath, target) {
+		t.Errorf("Expected the multi-disc album to be synced")
+	}
+
+	tracks, err := albumTracks(albumPath)
+	if err != nil {
+		t.Fatalf("Failed to list tracks: %v", err)
+	}
+	if len(tracks) != 3 || tracks[2].Name != "Disc 2/01.mp3" || tracks[2].DiscNumber != 2 {
+		t.Errorf("Unexpected tracks: %+v", tracks)
+	}
+}
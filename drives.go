@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DriveInfo is a starting point offered by the directory chooser. Path is
+// where browsing starts: the mount point, or the allowed root on it.
+type DriveInfo struct {
+	Path       string `json:"path"`
+	MountPath  string `json:"mountPath,omitempty"`
+	Device     string `json:"device,omitempty"`
+	FSType     string `json:"fsType,omitempty"`
+	Label      string `json:"label,omitempty"`
+	TotalBytes uint64 `json:"totalBytes"`
+	FreeBytes  uint64 `json:"freeBytes"`
+	Removable  bool   `json:"removable"`
+}
+
+// pseudoFilesystems never hold music and are hidden from the drive list.
+var pseudoFilesystems = []string{
+	"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs", "debugfs",
+	"devpts", "devtmpfs", "efivarfs", "fusectl", "hugetlbfs", "mqueue", "nsfs",
+	"overlay", "proc", "pstore", "ramfs", "rpc_pipefs", "securityfs",
+	"selinuxfs", "squashfs", "sysfs", "tmpfs", "tracefs",
+}
+
+func isPseudoFilesystem(volume Volume) bool {
+	if containsString(pseudoFilesystems, volume.FSType) {
+		return true
+	}
+	// Desktop helpers mount FUSE filesystems such as fuse.portal
+	if strings.HasPrefix(volume.FSType, "fuse.") && volume.FSType != "fuse.sshfs" {
+		return true
+	}
+	for _, system := range []string{"/proc", "/sys", "/dev", "/snap"} {
+		if isWithin(system, volume.MountPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRemovable reports whether the disk holding device (e.g. /dev/sdb1) has
+// its removable flag set in /sys/block.
+func isRemovable(sysBlock, device string) bool {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	name := filepath.Base(device)
+	if !strings.HasPrefix(device, "/dev/") {
+		return false
+	}
+
+	// Partitions live inside their disk's directory, e.g. sdb/sdb1
+	candidates := []string{filepath.Join(sysBlock, name, "removable")}
+	if disks, err := os.ReadDir(sysBlock); err == nil {
+		for _, disk := range disks {
+			if _, err := os.Stat(filepath.Join(sysBlock, disk.Name(), name)); err == nil {
+				candidates = append(candidates, filepath.Join(sysBlock, disk.Name(), "removable"))
+			}
+		}
+	}
+
+	for _, candidate := range candidates {
+		if data, err := os.ReadFile(candidate); err == nil {
+			return strings.TrimSpace(string(data)) == "1"
+		}
+	}
+	return false
+}
+
+// containingVolume returns the volume path is on: the one with the longest
+// mount point above it.
+func containingVolume(volumes []Volume, path string) (Volume, bool) {
+	var best Volume
+	found := false
+	for _, volume := range volumes {
+		if isWithin(volume.MountPath, path) && (!found || len(volume.MountPath) > len(best.MountPath)) {
+			best, found = volume, true
+		}
+	}
+	return best, found
+}
+
+// listDrives returns the allowed roots and every real filesystem mounted
+// inside one of them, such as USB drives under /media.
+func listDrives(volumes []Volume, roots []string, sysBlock string) []DriveInfo {
+	var drives []DriveInfo
+	seen := make(map[string]bool)
+
+	add := func(path string, volume Volume, mounted bool) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+
+		drive := DriveInfo{Path: path}
+		if mounted {
+			drive.MountPath = volume.MountPath
+			drive.Device = volume.Device
+			drive.FSType = volume.FSType
+			drive.Label = volume.Label
+			drive.Removable = isRemovable(sysBlock, volume.Device)
+		}
+		drive.TotalBytes, drive.FreeBytes, _ = diskSpace(path)
+		drives = append(drives, drive)
+	}
+
+	var realVolumes []Volume
+	for _, volume := range volumes {
+		if !isPseudoFilesystem(volume) {
+			realVolumes = append(realVolumes, volume)
+		}
+	}
+
+	for _, root := range roots {
+		if _, err := os.Stat(root); err != nil {
+			continue
+		}
+		volume, mounted := containingVolume(realVolumes, root)
+		add(root, volume, mounted)
+	}
+
+	var inside []Volume
+	for _, volume := range realVolumes {
+		for _, root := range roots {
+			if isWithin(root, volume.MountPath) {
+				inside = append(inside, volume)
+				break
+			}
+		}
+	}
+	sort.Slice(inside, func(i, j int) bool { return inside[i].MountPath < inside[j].MountPath })
+	for _, volume := range inside {
+		add(volume.MountPath, volume, true)
+	}
+
+	return drives
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListDrives(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "drives_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	home := filepath.Join(tempDir, "home", "anna")
+	media := filepath.Join(tempDir, "media")
+	usb := filepath.Join(media, "anna", "USB")
+	outside := filepath.Join(tempDir, "srv")
+	for _, dir := range []string{home, usb, outside} {
+		createAlbum(t, dir, nil)
+	}
+
+	// A fake /sys/block: sdb is a removable disk with one partition
+	sysBlock := filepath.Join(tempDir, "sys", "block")
+	createAlbum(t, filepath.Join(sysBlock, "sda"), []string{"removable"})
+	createAlbum(t, filepath.Join(sysBlock, "sdb", "sdb1"), nil)
+	os.WriteFile(filepath.Join(sysBlock, "sda", "removable"), []byte("0\n"), 0644)
+	os.WriteFile(filepath.Join(sysBlock, "sdb", "removable"), []byte("1\n"), 0644)
+
+	volumes := []Volume{
+		{Device: "/dev/sda2", MountPath: tempDir, FSType: "ext4"},
+		{Device: "/dev/sdb1", MountPath: usb, FSType: "vfat", Label: "USB"},
+		{Device: "tmpfs", MountPath: filepath.Join(media, "anna", "tmp"), FSType: "tmpfs"},
+		{Device: "/dev/sdc1", MountPath: outside, FSType: "ext4"},
+	}
+
+	drives := listDrives(volumes, []string{home, media}, sysBlock)
+	if len(drives) != 3 {
+		t.Fatalf("Expected home, media and the USB drive, got %+v", drives)
+	}
+	if drives[0].Path != home || drives[0].MountPath != tempDir || drives[0].Removable {
+		t.Errorf("Expected home on the fixed disk, got %+v", drives[0])
+	}
+	if drives[1].Path != media {
+		t.Errorf("Expected the media root, got %+v", drives[1])
+	}
+	usbDrive := drives[2]
+	if usbDrive.Path != usb || usbDrive.Label != "USB" || usbDrive.FSType != "vfat" || !usbDrive.Removable {
+		t.Errorf("Expected the removable USB drive, got %+v", usbDrive)
+	}
+	if usbDrive.TotalBytes == 0 || usbDrive.FreeBytes > usbDrive.TotalBytes {
+		t.Errorf("Expected disk space to be reported, got %d free of %d", usbDrive.FreeBytes, usbDrive.TotalBytes)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// fatMaxName is the longest name FAT32 and exFAT can store, in characters.
+const fatMaxName = 255
+
+// asciiFolds spells common non-ASCII letters and punctuation in ASCII, so an
+// ASCII-only device gets "Bjork" rather than "Bj_rk".
+var asciiFolds = func() map[rune]string {
+	folds := map[rune]string{
+		'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+		'đ': "d", 'Đ': "D", 'ł': "l", 'Ł': "L", 'þ': "th", 'Þ': "Th",
+		'‘': "'", '’': "'", '“': `"`, '”': `"`, '–': "-", '—': "-", '…': "...",
+	}
+	for ascii, letters := range map[string]string{
+		"a": "àáâãäåāăą", "A": "ÀÁÂÃÄÅĀĂĄ", "c": "çćč", "C": "ÇĆČ",
+		"e": "èéêëēęě", "E": "ÈÉÊËĒĘĚ", "i": "ìíîïī", "I": "ÌÍÎÏĪ",
+		"n": "ñńň", "N": "ÑŃŇ", "o": "òóôõöōő", "O": "ÒÓÔÕÖŌŐ",
+		"s": "śšş", "S": "ŚŠŞ", "u": "ùúûüūůű", "U": "ÙÚÛÜŪŮŰ",
+		"y": "ýÿ", "Y": "Ý", "z": "źżž", "Z": "ŹŻŽ",
+	} {
+		for _, r := range letters {
+			folds[r] = ascii
+		}
+	}
+	return folds
+}()
+
+// cleanName makes a single file or folder name valid under the rules. The
+// reason says why it had to change and is empty when it did not.
+func (rules FilenameRules) cleanName(name string) (string, string) {
+	replacement := rules.Replacement
+	if replacement == "" {
+		replacement = "_"
+	}
+	var reasons []string
+	cleaned := name
+
+	if rules.ASCIIOnly {
+		var b strings.Builder
+		for _, r := range cleaned {
+			switch fold, ok := asciiFolds[r]; {
+			case r < utf8.RuneSelf:
+				b.WriteRune(r)
+			case ok:
+				b.WriteString(fold)
+			default:
+				b.WriteString(replacement)
+			}
+		}
+		if b.String() != cleaned {
+			cleaned = b.String()
+			reasons = append(reasons, "name is not ASCII")
+		}
+	}
+
+	if rules.FATSafe {
+		var b strings.Builder
+		for _, r := range cleaned {
+			if r < 0x20 || strings.ContainsRune(fatInvalidChars, r) {
+				b.WriteString(replacement)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+		safe := strings.TrimRight(b.String(), ". ")
+		if safe == "" {
+			safe = replacement
+		}
+		if safe != cleaned {
+			cleaned = safe
+			reasons = append(reasons, "name is not valid on FAT devices")
+		}
+	}
+
+	maxLength := rules.MaxLength
+	if rules.FATSafe && (maxLength == 0 || maxLength > fatMaxName) {
+		maxLength = fatMaxName
+	}
+	if maxLength > 0 && utf8.RuneCountInString(cleaned) > maxLength {
+		cleaned = truncateName(cleaned, maxLength)
+		if rules.FATSafe {
+			cleaned = strings.TrimRight(cleaned, ". ")
+		}
+		reasons = append(reasons, fmt.Sprintf("name is longer than %d characters", maxLength))
+	}
+
+	return cleaned, strings.Join(reasons, "; ")
+}
+
+// truncateName shortens name to maxLength characters, keeping its extension
+// when there is room for it.
+func truncateName(name string, maxLength int) string {
+	ext := filepath.Ext(name)
+	if utf8.RuneCountInString(ext) >= maxLength {
+		ext = ""
+	}
+	stem := []rune(strings.TrimSuffix(name, ext))
+	keep := maxLength - utf8.RuneCountInString(ext)
+	if keep < len(stem) {
+		stem = stem[:keep]
+	}
+	return string(stem) + ext
+}
+
+// cleanPath applies cleanName to each part of a slash-separated path,
+// returning the reason the first changed part had to change.
+func (rules FilenameRules) cleanPath(path string) (string, string) {
+	parts := strings.Split(path, "/")
+	reason := ""
+	for i, part := range parts {
+		cleaned, why := rules.cleanName(part)
+		parts[i] = cleaned
+		if reason == "" {
+			reason = why
+		}
+	}
+	return strings.Join(parts, "/"), reason
+}
+
+// numberedName is name with " (n)" before its extension, for telling apart
+// files whose names became the same when cleaned.
+func numberedName(name string, n int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Inventory statuses for folders found on a target
+const (
+	statusSynced    = "synced"
+	statusOutOfDate = "out_of_date"
+	statusOrphan    = "orphan"
+	statusUnknown   = "unknown"
+)
+
+type InventoryItem struct {
+	Folder      string     `json:"folder"`
+	Status      string     `json:"status"`
+	SourcePath  string     `json:"source_path,omitempty"`
+	SyncedAt    *time.Time `json:"synced_at,omitempty"`
+	FileCount   int        `json:"file_count"`
+	SizeMB      float64    `json:"size_mb"`
+	DurationSec float64    `json:"duration_sec"`
+}
+
+type TargetInventory struct {
+	Folders     []InventoryItem `json:"folders"`
+	Counts      map[string]int  `json:"counts"`
+	SizeMB      float64         `json:"size_mb"`
+	DurationSec float64         `json:"duration_sec"`
+}
+
+// targetInventory walks targetDirectory and classifies every album folder on
+// it. Folders recorded in the manifest are synced, out of date or orphaned
+// relative to libraryDirectory (any library when empty); other folders that
+// hold audio files are unknown.
+func (s *Server) targetInventory(targetDirectory, libraryDirectory string) (TargetInventory, error) {
+	inventory := TargetInventory{
+		Folders: []InventoryItem{},
+		Counts:  map[string]int{},
+	}
+
+	manifest, err := loadManifest(targetDirectory)
+	if err != nil {
+		return inventory, err
+	}
+
+	err = walkTargetAlbums(targetDirectory, manifest, func(path, folder string, entry *SyncedAlbum) {
+		item := InventoryItem{Folder: folder, Status: statusUnknown}
+		if entry != nil {
+			syncedAt := entry.SyncedAt
+			item.Status = s.managedFolderStatus(*entry, path, libraryDirectory)
+			item.SourcePath = entry.SourcePath
+			item.SyncedAt = &syncedAt
+		}
+
+		fileCount, size, duration := folderContents(path)
+		item.FileCount = fileCount
+		item.SizeMB = float64(size) / 1024 / 1024
+		item.DurationSec = duration
+
+		inventory.Folders = append(inventory.Folders, item)
+		inventory.Counts[item.Status]++
+		inventory.SizeMB += item.SizeMB
+		inventory.DurationSec += item.DurationSec
+	})
+
+	sort.Slice(inventory.Folders, func(i, j int) bool {
+		return inventory.Folders[i].Folder < inventory.Folders[j].Folder
+	})
+
+	return inventory, err
+}
+
+// walkTargetAlbums calls visit for every album folder on targetDirectory
+// with its manifest entry, or nil for folders music-sync did not create that
+// hold audio files. It only lists directories and reads no files.
+func walkTargetAlbums(targetDirectory string, manifest TargetManifest, visit func(path, folder string, entry *SyncedAlbum)) error {
+	return filepath.WalkDir(targetDirectory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == targetDirectory {
+			return nil // Skip errors
+		}
+
+		// Skip the trash and other hidden folders
+		if strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(targetDirectory, path)
+		if err != nil {
+			return nil
+		}
+
+		if entry, ok := manifest.find(rel); ok {
+			visit(path, entry.Folder, &entry)
+		} else if containsAudio(path) {
+			visit(path, manifestFolder(rel), nil)
+		} else {
+			// Not an album itself, look further down (e.g. Artist/Album)
+			return nil
+		}
+		return filepath.SkipDir
+	})
+}
+
+func (s *Server) managedFolderStatus(entry SyncedAlbum, folderPath, libraryDirectory string) string {
+	if libraryDirectory != "" && !isWithin(libraryDirectory, entry.SourcePath) {
+		return statusOrphan
+	}
+	if _, err := os.Stat(entry.SourcePath); err != nil {
+		return statusOrphan
+	}
+
+	// The source changed since it was synced, or the copy no longer matches it
+	if s.generateFolderFingerprint(entry.SourcePath) != entry.Fingerprint {
+		return statusOutOfDate
+	}
+	if !sameFileNames(entry.SourcePath, folderPath, entry.Renamed) {
+		return statusOutOfDate
+	}
+
+	return statusSynced
+}
+
+// syncIndex tells whether albums are synced to one target, reading its
+// manifest once and fingerprinting its folders only once, when an album is
+// not in the manifest.
+type syncIndex struct {
+	server          *Server
+	targetDirectory string
+	manifest        TargetManifest
+	bySource        map[string]SyncedAlbum
+	byFingerprint   map[string]string
+}
+
+func (s *Server) newSyncIndex(targetDirectory string) *syncIndex {
+	manifest, err := loadManifest(targetDirectory)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	// Only albums still on the target count, whatever their folder is called
+	bySource := make(map[string]SyncedAlbum, len(manifest.Albums))
+	for _, entry := range manifest.Albums {
+		if _, seen := bySource[entry.SourcePath]; seen {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(targetDirectory, filepath.FromSlash(entry.Folder))); err == nil {
+			bySource[entry.SourcePath] = entry
+		}
+	}
+	return &syncIndex{server: s, targetDirectory: targetDirectory, manifest: manifest, bySource: bySource}
+}
+
+// synced reports whether sourcePath has an up-to-date copy on the target:
+// one recorded in the manifest, or a folder with the same name and files that
+// is not recorded as a copy of a different album.
+func (idx *syncIndex) synced(sourcePath string) bool {
+	sourceFingerprint := idx.server.generateFolderFingerprint(sourcePath)
+	if sourceFingerprint == "" {
+		return false
+	}
+	if entry, ok := idx.bySource[sourcePath]; ok {
+		return entry.Fingerprint == sourceFingerprint
+	}
+
+	if idx.byFingerprint == nil {
+		idx.byFingerprint = make(map[string]string)
+		entries, _ := os.ReadDir(idx.targetDirectory)
+		for _, entry := range entries {
+			// Skip the trash and other hidden folders
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				fingerprint := idx.server.generateFolderFingerprint(filepath.Join(idx.targetDirectory, entry.Name()))
+				if _, seen := idx.byFingerprint[fingerprint]; !seen {
+					idx.byFingerprint[fingerprint] = entry.Name()
+				}
+			}
+		}
+	}
+	folder, ok := idx.byFingerprint[sourceFingerprint]
+	if !ok {
+		return false
+	}
+	if entry, ok := idx.manifest.find(folder); ok && entry.SourcePath != sourcePath {
+		return false
+	}
+	return true
+}
+
+// containsAudio reports whether path holds audio files, directly or in disc
+// folders.
+func containsAudio(path string) bool {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && isAudioFile(entry.Name()) {
+			return true
+		}
+		if _, ok := discNumber(entry.Name()); ok && entry.IsDir() && containsAudio(filepath.Join(path, entry.Name())) {
+			return true
+		}
+	}
+	return false
+}
+
+// folderContents counts the files below path, their total size in bytes and
+// the playing time of the audio files among them.
+func folderContents(path string) (int, int64, float64) {
+	var count int
+	var size int64
+	var duration float64
+
+	filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		count++
+		size += info.Size()
+		if isAudioFile(d.Name()) {
+			audio, _ := readAudioInfo(path)
+			duration += audio.DurationSec
+		}
+		return nil
+	})
+
+	return count, size, duration
+}
+
+// sameFileNames reports whether two album folders contain the same file
+// names, disc folders included, regardless of the folder names themselves.
+// Files of a listed in renamed are expected in b under their new names.
+func sameFileNames(a, b string, renamed map[string]string) bool {
+	namesA, errA := albumFiles(a)
+	namesB, errB := albumFiles(b)
+	if errA != nil || errB != nil || len(namesA) != len(namesB) {
+		return false
+	}
+	if len(renamed) > 0 {
+		for i, name := range namesA {
+			if to, ok := renamed[name]; ok {
+				namesA[i] = to
+			}
+		}
+		sort.Strings(namesA)
+	}
+	for i := range namesA {
+		if namesA[i] != namesB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// albumFiles lists the files of the album folder at path, sorted, with
+// those in its disc folders named like albumFileNames does ("CD1/01.mp3").
+func albumFiles(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+			continue
+		}
+		if _, ok := discNumber(entry.Name()); !ok {
+			continue
+		}
+		discEntries, err := os.ReadDir(filepath.Join(path, entry.Name()))
+		if err != nil {
+			continue
+		}
+		for _, discEntry := range discEntries {
+			if !discEntry.IsDir() {
+				names = append(names, entry.Name()+"/"+discEntry.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Server) handleTargetInventory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	targetDirectory, err := resolveAllowed(r.URL.Query().Get("targetDirectory"), s.targetRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+
+	// Optional: classify against this library only
+	libraryDirectory := ""
+	if source := r.URL.Query().Get("sourceDirectory"); source != "" {
+		libraryDirectory, err = resolveAllowed(source, s.libraryRoots())
+		if err != nil {
+			writePathError(w, err)
+			return
+		}
+	}
+
+	inventory, err := s.targetInventory(targetDirectory, libraryDirectory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inventory)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTargetInventory(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "inventory_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	targetDir := filepath.Join(tempDir, "target")
+	current := filepath.Join(library, "Artist", "Current")
+	changed := filepath.Join(library, "Artist", "Changed")
+	removed := filepath.Join(library, "Artist", "Removed")
+	createAlbum(t, current, []string{"01.mp3"})
+	createAlbum(t, changed, []string{"01.mp3"})
+	createAlbum(t, removed, []string{"01.mp3"})
+	// Copied by hand, nested one level down
+	createAlbum(t, filepath.Join(targetDir, "Someone", "Manual"), []string{"01.mp3", "02.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	for _, album := range []string{current, changed, removed} {
+		if _, err := server.syncAlbum(album, targetDir, SyncOptions{}); err != nil {
+			t.Fatalf("Sync of %s failed: %v", album, err)
+		}
+	}
+
+	// Change and remove sources after syncing
+	createAlbum(t, changed, []string{"02.mp3"})
+	server.invalidateFingerprint(changed)
+	if err := os.RemoveAll(removed); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+
+	inventory, err := server.targetInventory(targetDir, library)
+	if err != nil {
+		t.Fatalf("Inventory failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"Current":        statusSynced,
+		"Changed":        statusOutOfDate,
+		"Removed":        statusOrphan,
+		"Someone/Manual": statusUnknown,
+	}
+	if len(inventory.Folders) != len(expected) {
+		t.Fatalf("Expected %d folders, got %+v", len(expected), inventory.Folders)
+	}
+	for _, item := range inventory.Folders {
+		if expected[item.Folder] != item.Status {
+			t.Errorf("Expected %s to be %s, got %s", item.Folder, expected[item.Folder], item.Status)
+		}
+		if item.Folder == "Someone/Manual" && item.FileCount != 2 {
+			t.Errorf("Expected 2 files in %s, got %d", item.Folder, item.FileCount)
+		}
+	}
+
+	// Albums from another library are orphans relative to this one
+	inventory, err = server.targetInventory(targetDir, filepath.Join(tempDir, "other"))
+	if err != nil {
+		t.Fatalf("Inventory failed: %v", err)
+	}
+	if inventory.Counts[statusOrphan] != 3 {
+		t.Errorf("Expected 3 orphans, got %v", inventory.Counts)
+	}
+}
+
+func TestInventoryComparesDiscFolders(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "inventory_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	targetDir := filepath.Join(tempDir, "target")
+	album := filepath.Join(library, "Artist", "Album")
+	createAlbum(t, filepath.Join(album, "CD1"), []string{"01.mp3"})
+	createAlbum(t, filepath.Join(album, "CD2"), []string{"01.mp3", "02.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+	if _, err := server.syncAlbum(album, targetDir, SyncOptions{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	status := func() string {
+		t.Helper()
+		inventory, err := server.targetInventory(targetDir, library)
+		if err != nil {
+			t.Fatalf("Inventory failed: %v", err)
+		}
+		if len(inventory.Folders) != 1 {
+			t.Fatalf("Expected 1 folder, got %+v", inventory.Folders)
+		}
+		return inventory.Folders[0].Status
+	}
+	if got := status(); got != statusSynced {
+		t.Fatalf("Expected the copy to be synced, got %s", got)
+	}
+
+	// A track missing from the copy's second disc makes it out of date
+	if err := os.Remove(filepath.Join(targetDir, "Album", "CD2", "02.mp3")); err != nil {
+		t.Fatalf("Failed to remove track: %v", err)
+	}
+	if got := status(); got != statusOutOfDate {
+		t.Errorf("Expected a changed disc folder to be out of date, got %s", got)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultLibraryPatterns are used when the settings do not list any:
+// "Artist/Album" folders, or "Artist - Album" folders.
+var defaultLibraryPatterns = []string{"{artist}/{album}", "{artist} - {album}"}
+
+// libraryPatternPlaceholders are the fields a library pattern may capture.
+var libraryPatternPlaceholders = []string{"artist", "album", "year", "genre"}
+
+// unknownArtist names the artist of albums no pattern matches.
+const unknownArtist = "Unknown Artist"
+
+// libraryPattern is a compiled library pattern such as
+// "{artist} - {year} - {album}". It matches the last segments of an album's
+// path below the library root, one per folder in the pattern.
+type libraryPattern struct {
+	source   string
+	segments int
+	pattern  *regexp.Regexp
+}
+
+func compileLibraryPattern(source string) (*libraryPattern, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, errors.New("empty library pattern")
+	}
+	if strings.HasPrefix(source, "/") || strings.Contains(source, "..") {
+		return nil, fmt.Errorf("library pattern %q must be relative to the library root", source)
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	seen := make(map[string]bool)
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(source, -1) {
+		literal := source[last:match[0]]
+		if strings.ContainsAny(literal, "{}") {
+			return nil, fmt.Errorf("unbalanced braces in library pattern %q", source)
+		}
+		expr.WriteString(regexp.QuoteMeta(literal))
+
+		name := source[match[2]:match[3]]
+		if !containsString(libraryPatternPlaceholders, name) {
+			return nil, fmt.Errorf("unknown library pattern placeholder {%s}", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("placeholder {%s} appears twice in library pattern %q", name, source)
+		}
+		seen[name] = true
+		if name == "year" {
+			expr.WriteString(`(?P<year>\d{4})`)
+		} else {
+			fmt.Fprintf(&expr, `(?P<%s>[^/]+?)`, name)
+		}
+		last = match[1]
+	}
+	if strings.ContainsAny(source[last:], "{}") {
+		return nil, fmt.Errorf("unbalanced braces in library pattern %q", source)
+	}
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("library pattern %q has no placeholders", source)
+	}
+	expr.WriteString(regexp.QuoteMeta(source[last:]))
+	expr.WriteString("$")
+
+	return &libraryPattern{
+		source:   source,
+		segments: strings.Count(source, "/") + 1,
+		pattern:  regexp.MustCompile(expr.String()),
+	}, nil
+}
+
+// compileLibraryPatterns compiles sources in order, or the default patterns
+// when there are none.
+func compileLibraryPatterns(sources []string) ([]*libraryPattern, error) {
+	if len(sources) == 0 {
+		sources = defaultLibraryPatterns
+	}
+	patterns := make([]*libraryPattern, 0, len(sources))
+	for _, source := range sources {
+		pattern, err := compileLibraryPattern(source)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// ParsedPath is what the library patterns make of an album folder's path.
+// Pattern is the one that matched, empty when none did.
+type ParsedPath struct {
+	Path     string `json:"path"`
+	Relative string `json:"relative"`
+	Pattern  string `json:"pattern,omitempty"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	Year     int    `json:"year,omitempty"`
+	Genre    string `json:"genre,omitempty"`
+}
+
+// libraryNaming parses album paths below root with patterns, trying them in
+// order.
+type libraryNaming struct {
+	root     string
+	patterns []*libraryPattern
+}
+
+// parse names the album at path. Without a matching pattern the album is
+// named after its folder, by an unknown artist.
+func (n libraryNaming) parse(path string) ParsedPath {
+	relative := path
+	if n.root != "" && isWithin(n.root, path) {
+		if rel, err := filepath.Rel(n.root, path); err == nil {
+			relative = rel
+		}
+	}
+	relative = filepath.ToSlash(relative)
+
+	var segments []string
+	for _, segment := range strings.Split(relative, "/") {
+		if segment != "" && segment != "." {
+			segments = append(segments, segment)
+		}
+	}
+
+	parsed := ParsedPath{Path: path, Relative: strings.Join(segments, "/")}
+	for _, pattern := range n.patterns {
+		if pattern.segments > len(segments) {
+			continue
+		}
+		match := pattern.pattern.FindStringSubmatch(strings.Join(segments[len(segments)-pattern.segments:], "/"))
+		if match == nil {
+			continue
+		}
+		parsed.Pattern = pattern.source
+		for i, name := range pattern.pattern.SubexpNames() {
+			value := strings.TrimSpace(match[i])
+			switch name {
+			case "artist":
+				parsed.Artist = value
+			case "album":
+				parsed.Album = value
+			case "year":
+				parsed.Year, _ = strconv.Atoi(value)
+			case "genre":
+				parsed.Genre = value
+			}
+		}
+		break
+	}
+
+	if parsed.Album == "" {
+		parsed.Album = filepath.Base(path)
+	}
+	if parsed.Artist == "" {
+		parsed.Artist = unknownArtist
+	}
+	return parsed
+}
+
+// libraryNaming parses album paths below root with the configured patterns.
+// Invalid patterns in a hand-edited settings file fall back to the defaults.
+func (s *Server) libraryNaming(root string) libraryNaming {
+	patterns, err := compileLibraryPatterns(s.loadSettings().LibraryPatterns)
+	if err != nil {
+		log.Printf("Warning: Using the default library patterns: %v", err)
+		patterns, _ = compileLibraryPatterns(nil)
+	}
+	return libraryNaming{root: root, patterns: patterns}
+}
+
+// libraryRootOf is the library root path belongs to: the deepest of the last
+// scanned directory, the watched library and the allowed library roots that
+// contains it, or "" when none does.
+func (s *Server) libraryRootOf(path string) string {
+	candidates := append([]string{s.loadSettings().LastSourceDirectory}, s.libraryRoots()...)
+	s.watcherMutex.Lock()
+	candidates = append(candidates, s.watchedRoot)
+	s.watcherMutex.Unlock()
+
+	root := ""
+	for _, candidate := range candidates {
+		if candidate != "" && isWithin(candidate, path) && len(candidate) > len(root) {
+			root = candidate
+		}
+	}
+	return root
+}
+
+// handleLibraryPatterns reads and replaces the library patterns. Saving an
+// empty list restores the defaults.
+func (s *Server) handleLibraryPatterns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		patterns := s.loadSettings().LibraryPatterns
+		if len(patterns) == 0 {
+			patterns = defaultLibraryPatterns
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"patterns": patterns})
+	case http.MethodPut:
+		var req struct {
+			Patterns []string `json:"patterns"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := compileLibraryPatterns(req.Patterns); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := s.updateSettings(func(settings *AppSettings) error {
+			settings.LibraryPatterns = req.Patterns
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "saved"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleParsePath shows how GET /api/library/parse?path=... would be named,
+// relative to root (or the library root it is in), using the given pattern
+// parameters in order or else the configured patterns. Nothing is read from
+// disk, so the path does not have to exist.
+func (s *Server) handleParsePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		http.Error(w, "Path required", http.StatusBadRequest)
+		return
+	}
+	path = filepath.Clean(path)
+
+	naming := s.libraryNaming(query.Get("root"))
+	if naming.root == "" {
+		naming.root = s.libraryRootOf(path)
+	} else {
+		naming.root = filepath.Clean(naming.root)
+	}
+	if sources := query["pattern"]; len(sources) > 0 {
+		patterns, err := compileLibraryPatterns(sources)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		naming.patterns = patterns
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(naming.parse(path))
+}
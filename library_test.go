@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLibraryPatterns(t *testing.T) {
+	patterns, err := compileLibraryPatterns([]string{
+		"{genre}/{artist}/{album}",
+		"{artist} - {year} - {album}",
+		"{artist} - {album}",
+		"Singles/{album}",
+	})
+	if err != nil {
+		t.Fatalf("Failed to compile patterns: %v", err)
+	}
+	naming := libraryNaming{root: "/music", patterns: patterns}
+
+	tests := []struct {
+		path    string
+		pattern string
+		artist  string
+		album   string
+		year    int
+		genre   string
+	}{
+		{"/music/Hip-Hop/Jay-Z/The Black Album", "{genre}/{artist}/{album}", "Jay-Z", "The Black Album", 0, "Hip-Hop"},
+		{"/music/Jay-Z - 2003 - The Black Album", "{artist} - {year} - {album}", "Jay-Z", "The Black Album", 2003, ""},
+		{"/music/Jay-Z - The Black Album", "{artist} - {album}", "Jay-Z", "The Black Album", 0, ""},
+		{"/music/Singles/Summer", "Singles/{album}", unknownArtist, "Summer", 0, ""},
+		{"/music/AC_DC", "", unknownArtist, "AC_DC", 0, ""},
+	}
+	for _, test := range tests {
+		parsed := naming.parse(test.path)
+		if parsed.Pattern != test.pattern || parsed.Artist != test.artist || parsed.Album != test.album || parsed.Year != test.year || parsed.Genre != test.genre {
+			t.Errorf("Unexpected parse of %s: %+v", test.path, parsed)
+		}
+	}
+
+	// Folders above the library root are never matched
+	defaults, _ := compileLibraryPatterns(nil)
+	if parsed := (libraryNaming{root: "/music", patterns: defaults}).parse("/music/Back in Black"); parsed.Artist != unknownArtist {
+		t.Errorf("Expected the library root not to be the artist, got %+v", parsed)
+	}
+
+	for _, pattern := range []string{"", "{artist}/{title}", "/{artist}/{album}", "../{album}", "{artist}/{album", "{artist} - {artist}", "Music"} {
+		if _, err := compileLibraryPattern(pattern); err == nil {
+			t.Errorf("Expected %q to be rejected", pattern)
+		}
+	}
+}
+
+func TestScanUsesLibraryPatterns(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "library_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	createAlbum(t, filepath.Join(library, "AC-DC", "1980 - Back in Black"), []string{"01.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+	}
+
+	// The default "{artist}/{album}" keeps hyphens in the artist
+	albums := server.scanMusicFolders(library)
+	if len(albums) != 1 || albums[0].Artist != "AC-DC" || albums[0].Album != "1980 - Back in Black" {
+		t.Fatalf("Unexpected album with the default patterns: %+v", albums)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/library/patterns", strings.NewReader(`{"patterns": ["{artist}/{year} - {album}"]}`))
+	w := httptest.NewRecorder()
+	server.handleLibraryPatterns(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to save patterns: %d %s", w.Code, w.Body.String())
+	}
+	albums = server.scanMusicFolders(library)
+	if len(albums) != 1 || albums[0].Album != "Back in Black" || albums[0].Year != 1980 {
+		t.Errorf("Unexpected album with the saved pattern: %+v", albums)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/api/library/patterns", strings.NewReader(`{"patterns": ["{artist}/{label}"]}`))
+	w = httptest.NewRecorder()
+	server.handleLibraryPatterns(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown placeholder to be rejected, got %d", w.Code)
+	}
+
+	// Trying a pattern does not need the folder to exist or the pattern saved
+	query := url.Values{
+		"path":    {filepath.Join(library, "Rock", "Jay-Z - The Black Album")},
+		"root":    {library},
+		"pattern": {"{genre}/{artist} - {album}"},
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/library/parse?"+query.Encode(), nil)
+	w = httptest.NewRecorder()
+	server.handleParsePath(w, req)
+	var parsed ParsedPath
+	if err := json.NewDecoder(w.Body).Decode(&parsed); err != nil {
+		t.Fatalf("Failed to decode parse: %v", err)
+	}
+	if parsed.Relative != "Rock/Jay-Z - The Black Album" || parsed.Artist != "Jay-Z" || parsed.Genre != "Rock" {
+		t.Errorf("Unexpected parse: %+v", parsed)
+	}
+}
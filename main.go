@@ -5,6 +5,7 @@ import (
 	"embed"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
@@ -16,21 +17,40 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 //go:embed dist/*
 var staticFiles embed.FS
 
 type AlbumFolder struct {
-	Path        string  `json:"path"`
-	Name        string  `json:"name"`
-	Artist      string  `json:"artist"`
-	Album       string  `json:"album"`
-	Mp3Count    int     `json:"mp3_count"`
-	HasCover    bool    `json:"has_cover"`
-	SizeMB      float64 `json:"size_mb"`
-	IsSynced    bool    `json:"is_synced"`
-	Fingerprint string  `json:"fingerprint"`
+	Path         string         `json:"path"`
+	Name         string         `json:"name"`
+	Artist       string         `json:"artist"`
+	Album        string         `json:"album"`
+	Mp3Count     int            `json:"mp3_count"`
+	HasCover     bool           `json:"has_cover"`
+	SizeMB       float64        `json:"size_mb"`
+	IsSynced     bool           `json:"is_synced"`
+	Fingerprint  string         `json:"fingerprint"`
+	Genre        string         `json:"genre,omitempty"`
+	Year         int            `json:"year,omitempty"`
+	Format       string         `json:"format"`
+	AddedAt      time.Time      `json:"added_at"`
+	DurationSec  float64        `json:"duration_sec"`
+	BitrateKbps  int            `json:"bitrate_kbps,omitempty"`
+	Codec        string         `json:"codec,omitempty"`
+	Lossless     bool           `json:"lossless"`
+	FormatCounts map[string]int `json:"format_counts"`
+	TrackCount   int            `json:"track_count"`
+	Discs        []AlbumDisc    `json:"discs,omitempty"`
+	Compilation  bool           `json:"compilation"`
+	AlbumArtist  string         `json:"album_artist,omitempty"`
+	Date         string         `json:"date,omitempty"`
+	Label        string         `json:"label,omitempty"`
+	ReleaseID    string         `json:"musicbrainz_release_id,omitempty"`
+	TotalDiscs   int            `json:"total_discs,omitempty"`
+	Tracks       []Track        `json:"-"`
 }
 
 type DirectoryItem struct {
@@ -40,8 +60,16 @@ type DirectoryItem struct {
 }
 
 type AppSettings struct {
-	LastSourceDirectory string `json:"lastSourceDirectory"`
-	LastTargetDirectory string `json:"lastTargetDirectory"`
+	LastSourceDirectory string          `json:"lastSourceDirectory"`
+	LastTargetDirectory string          `json:"lastTargetDirectory"`
+	LibraryRoots        []string        `json:"libraryRoots,omitempty"`
+	TargetRoots         []string        `json:"targetRoots,omitempty"`
+	ConflictPolicy      string          `json:"conflictPolicy,omitempty"`
+	SyncSets            []SyncSet       `json:"syncSets,omitempty"`
+	Devices             []DeviceProfile `json:"devices,omitempty"`
+	ActiveDevice        string          `json:"activeDevice,omitempty"`
+	WatchLibrary        bool            `json:"watchLibrary,omitempty"`
+	LibraryPatterns     []string        `json:"libraryPatterns,omitempty"`
 }
 
 type Server struct {
@@ -49,6 +77,19 @@ type Server struct {
 	fingerprintCache map[string]string
 	cacheMutex       sync.RWMutex
 	settingsFile     string
+	authToken        string
+	manifestMutex    sync.Mutex
+	settingsMutex    sync.Mutex
+	lastScan         []AlbumFolder
+	lastScanRoot     string
+	lastScanMutex    sync.RWMutex
+	events           *eventHub
+	autoSyncRuns     []AutoSyncRun
+	autoSyncMutex    sync.Mutex
+	libraryWatcher   libraryWatcher
+	watchedRoot      string
+	watcherMutex     sync.Mutex
+	catalog          *libraryCatalog
 }
 
 func main() {
@@ -61,27 +102,64 @@ func main() {
 	execDir := filepath.Dir(execPath)
 	settingsFile := filepath.Join(execDir, "music-sync-settings.json")
 	
+	// Per-launch secret required on every API call
+	authToken, err := generateToken()
+	if err != nil {
+		log.Fatalf("Could not generate auth token: %v", err)
+	}
+	
 	server := &Server{
 		port:             "8080",
 		fingerprintCache: make(map[string]string),
 		settingsFile:     settingsFile,
+		authToken:        authToken,
+		events:           newEventHub(),
+		catalog:          loadCatalog(filepath.Join(execDir, "music-sync-catalog.json")),
+	}
+	
+	// Show the last scanned library at once; a rescan only revisits what changed
+	if lastSource := server.loadSettings().LastSourceDirectory; lastSource != "" {
+		server.lastScan = server.catalog.albums(lastSource)
+		server.lastScanRoot = lastSource
 	}
 	
+	serverURL := fmt.Sprintf("http://localhost:%s/?token=%s", server.port, server.authToken)
+	
 	// Print URL to console
 	fmt.Printf("🎵 Music Sync Server starting...\n")
-	fmt.Printf("📡 Server URL: http://localhost:%s\n", server.port)
+	fmt.Printf("📡 Server URL: %s\n", serverURL)
 	
 	// Try to open browser
-	go openBrowser(fmt.Sprintf("http://localhost:%s", server.port))
+	go openBrowser(serverURL)
 	
 	// Set up routes
 	http.HandleFunc("/api/scan", server.handleScan)
+	http.HandleFunc("/api/scan/stream", server.handleScanStream)
+	http.HandleFunc("/api/catalog", server.handleCatalog)
+	http.HandleFunc("/api/albums", server.handleAlbums)
+	http.HandleFunc("/api/albums/", server.handleAlbum)
+	http.HandleFunc("/api/albums/summary", server.handleAlbumSummary)
 	http.HandleFunc("/api/drives", server.handleDrives)
 	http.HandleFunc("/api/browse", server.handleBrowse)
 	http.HandleFunc("/api/check-sync", server.handleCheckSync)
 	http.HandleFunc("/api/sync", server.handleSync)
 	http.HandleFunc("/api/unsync", server.handleUnsync)
+	http.HandleFunc("/api/unsync/undo", server.handleUndoUnsync)
+	http.HandleFunc("/api/trash", server.handleTrash)
+	http.HandleFunc("/api/trash/purge", server.handlePurgeTrash)
+	http.HandleFunc("/api/target/inventory", server.handleTargetInventory)
+	http.HandleFunc("/api/mirror", server.handleMirror)
+	http.HandleFunc("/api/sync-sets", server.handleSyncSets)
+	http.HandleFunc("/api/sync-sets/", server.handleSyncSet)
+	http.HandleFunc("/api/rules/evaluate", server.handleEvaluateRules)
+	http.HandleFunc("/api/devices", server.handleDevices)
+	http.HandleFunc("/api/devices/", server.handleDevice)
+	http.HandleFunc("/api/auto-sync", server.handleAutoSync)
+	http.HandleFunc("/api/events", server.handleEvents)
 	http.HandleFunc("/api/cover/", server.handleCover)
+	http.HandleFunc("/api/library/patterns", server.handleLibraryPatterns)
+	http.HandleFunc("/api/library/parse", server.handleParsePath)
+	http.HandleFunc("/api/roots", server.handleRoots)
 	http.HandleFunc("/api/settings", server.handleSettings)
 	
 	// Serve static files (React build) from embedded files
@@ -106,8 +184,11 @@ func main() {
 		fsHandler.ServeHTTP(w, r)
 	}))
 	
+	// Apply sync sets to devices as they are plugged in
+	go server.watchDevices(deviceWatchInterval)
+	
 	fmt.Printf("🚀 Server running on http://localhost:%s\n", server.port)
-	log.Fatal(http.ListenAndServe(":"+server.port, nil))
+	log.Fatal(http.ListenAndServe(":"+server.port, server.requireAuth(http.DefaultServeMux)))
 }
 
 func openBrowser(url string) {
@@ -122,7 +203,7 @@ func openBrowser(url string) {
 	}
 	if err != nil {
 		fmt.Printf("⚠️  Could not open browser automatically: %v\n", err)
-		fmt.Printf("🌐 Please open http://localhost:8080 manually\n")
+		fmt.Printf("🌐 Please open %s manually\n", url)
 	}
 }
 
@@ -140,19 +221,46 @@ func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	albums := s.scanMusicFolders(req.Directory)
+	directory, err := resolveAllowed(req.Directory, s.libraryRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	
+	albums := s.scanMusicFolders(directory)
+	s.storeScan(directory, albums)
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(albums)
 }
 
+// storeScan keeps the albums found in directory for resolving saved
+// selections by fingerprint, and when enabled watches directory so they stay
+// current.
+func (s *Server) storeScan(directory string, albums []AlbumFolder) {
+	s.lastScanMutex.Lock()
+	s.lastScan = albums
+	s.lastScanRoot = directory
+	s.lastScanMutex.Unlock()
+	
+	if s.loadSettings().WatchLibrary {
+		s.watchLibrary(directory)
+	} else {
+		s.stopWatchingLibrary()
+	}
+}
+
 func (s *Server) handleDrives(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	
-	drives := getDrives()
+	// Only offer starting points the browser is allowed to open
+	drives := listDrives(listVolumes(), s.allowedRoots(), "/sys/block")
+	if drives == nil {
+		drives = []DriveInfo{}
+	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(drives)
@@ -172,7 +280,13 @@ func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	items, err := browseDirectory(req.Path)
+	path, err := resolveAllowed(req.Path, s.allowedRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	
+	items, err := browseDirectory(path)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -197,7 +311,18 @@ func (s *Server) handleCheckSync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	isSynced := s.checkSyncStatus(req.SourcePath, req.TargetDirectory)
+	sourcePath, err := resolveAllowed(req.SourcePath, s.libraryRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	targetDirectory, err := resolveAllowed(req.TargetDirectory, s.targetRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	
+	isSynced := s.checkSyncStatus(sourcePath, targetDirectory)
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"synced": isSynced})
@@ -212,20 +337,46 @@ func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		SourcePath      string `json:"sourcePath"`
 		TargetDirectory string `json:"targetDirectory"`
+		SyncOptions
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	
-	result, err := syncAlbum(req.SourcePath, req.TargetDirectory)
+	sourcePath, err := resolveAllowed(req.SourcePath, s.libraryRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	targetDirectory, err := resolveAllowed(req.TargetDirectory, s.targetRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	
+	options, err := s.syncOptions(req.SyncOptions, targetDirectory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	result, err := s.syncAlbum(sourcePath, targetDirectory, options)
+	if errors.Is(err, errConfirmationRequired) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errInsufficientSpace) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"result": result})
+	json.NewEncoder(w).Encode(result)
 }
 
 func (s *Server) handleUnsync(w http.ResponseWriter, r *http.Request) {
@@ -237,20 +388,56 @@ func (s *Server) handleUnsync(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		TargetDirectory string `json:"targetDirectory"`
 		AlbumName       string `json:"albumName"`
+		SourcePath      string `json:"sourcePath"`
+		Adopt           bool   `json:"adopt"`
+		DryRun          bool   `json:"dryRun"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	
-	result, err := unsyncAlbum(req.TargetDirectory, req.AlbumName)
+	targetDirectory, err := resolveAllowed(req.TargetDirectory, s.targetRoots())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writePathError(w, err)
+		return
+	}
+	
+	// The source is optional; with adopt it recognises copies made before
+	// the target had a manifest
+	sourcePath := ""
+	if req.SourcePath != "" {
+		sourcePath, err = resolveAllowed(req.SourcePath, s.libraryRoots())
+		if err != nil {
+			writePathError(w, err)
+			return
+		}
+	}
+	
+	// Folders music-sync did not create conflict with the request; the
+	// client may retry with adopt for an exact copy of the source
+	trashed, plan, err := s.unsyncAlbum(targetDirectory, req.AlbumName, sourcePath, req.Adopt, req.DryRun)
+	if errors.Is(err, errUnmanagedAlbum) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	
+	result := fmt.Sprintf("Moved %s to trash", trashed.Folder)
+	if req.DryRun {
+		result = fmt.Sprintf("Would move %s to trash", trashed.Folder)
+	}
+	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"result": result})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"result":      result,
+		"trashFolder": trashed.TrashFolder,
+		"dryRun":      req.DryRun,
+		"plan":        plan,
+	})
 }
 
 func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
@@ -266,6 +453,13 @@ func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	roots := s.allowedRoots()
+	albumPath, err := resolveAllowed(albumPath, roots)
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	
 	// Find cover image (check album directory and parent directory)
 	coverPath, found := findCoverImage(albumPath)
 	if !found {
@@ -273,84 +467,40 @@ func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// The cover itself may be a symlink or sit in the parent folder, so check it too
+	coverPath, err = resolveAllowed(coverPath, roots)
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+	
 	// Serve the image file
 	w.Header().Set("Content-Type", "image/jpeg")
 	http.ServeFile(w, r, coverPath)
 }
 
-func (s *Server) scanMusicFolders(directory string) []AlbumFolder {
-	var albums []AlbumFolder
-	
-	err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return nil // Skip errors
+// dominantFormat returns the extension most of an album's tracks use.
+func dominantFormat(counts map[string]int) string {
+	format := ""
+	for ext, count := range counts {
+		if count > counts[format] || (count == counts[format] && ext < format) {
+			format = ext
 		}
-		
-		if d.IsDir() && path != directory {
-			// Check if this directory contains any audio files
-			audioCount := 0
-			mp3Count := 0
-			dirEntries, err := os.ReadDir(path)
-			if err != nil {
-				return nil
-			}
-			
-			audioExtensions := []string{".mp3", ".flac", ".m4a", ".aac", ".ogg", ".wav", ".wma"}
-			
-			for _, entry := range dirEntries {
-				if !entry.IsDir() {
-					fileName := strings.ToLower(entry.Name())
-					if strings.HasSuffix(fileName, ".mp3") {
-						mp3Count++
-						audioCount++
-					} else {
-						for _, ext := range audioExtensions {
-							if strings.HasSuffix(fileName, ext) {
-								audioCount++
-								break
-							}
-						}
-					}
-				}
-			}
-			
-			// Include folder if it has any audio files
-			if audioCount > 0 {
-				folderName := filepath.Base(path)
-				parentFolderName := filepath.Base(filepath.Dir(path))
-				artist, album := parseArtistAndAlbum(parentFolderName, folderName)
-				
-				// Check for cover.jpg (in album directory or parent)
-				_, hasCover := findCoverImage(path)
-				
-				// Calculate folder size
-				sizeMB := calculateFolderSize(path)
-				
-				// Generate fingerprint
-				fingerprint := s.generateFolderFingerprint(path)
-				
-				albums = append(albums, AlbumFolder{
-					Path:        path,
-					Name:        folderName,
-					Artist:      artist,
-					Album:       album,
-					Mp3Count:    mp3Count,
-					HasCover:    hasCover,
-					SizeMB:      sizeMB,
-					IsSynced:    false,
-					Fingerprint: fingerprint,
-				})
-			}
+	}
+	return format
+}
+
+var audioExtensions = []string{".mp3", ".flac", ".m4a", ".aac", ".ogg", ".wav", ".wma",
+	".opus", ".aiff", ".aif", ".ape", ".wv", ".dsf"}
+
+func isAudioFile(fileName string) bool {
+	fileName = strings.ToLower(fileName)
+	for _, ext := range audioExtensions {
+		if strings.HasSuffix(fileName, ext) {
+			return true
 		}
-		
-		return nil
-	})
-	
-	if err != nil {
-		log.Printf("Error scanning directory: %v", err)
 	}
-	
-	return albums
+	return false
 }
 
 func getDrives() []string {
@@ -383,26 +533,32 @@ func (s *Server) generateFolderFingerprint(folderPath string) string {
 	}
 	s.cacheMutex.RUnlock()
 	
-	folderName := filepath.Base(folderPath)
-	
-	// Get list of files in the folder
-	entries, err := os.ReadDir(folderPath)
+	// Files in disc folders count as "CD1/01.mp3", so a multi-disc album
+	// is fingerprinted as a whole
+	files, err := albumFiles(folderPath)
 	if err != nil {
 		return ""
 	}
 	
-	var files []string
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			files = append(files, entry.Name())
-		}
+	return s.cachedFingerprint(folderPath, files)
+}
+
+// cachedFingerprint returns folderPath's cached fingerprint, or computes it
+// from the names of the album's files and caches it.
+func (s *Server) cachedFingerprint(folderPath string, files []string) string {
+	s.cacheMutex.RLock()
+	if fingerprint, exists := s.fingerprintCache[folderPath]; exists {
+		s.cacheMutex.RUnlock()
+		return fingerprint
 	}
+	s.cacheMutex.RUnlock()
 	
 	// Sort files for consistent fingerprint
+	files = append([]string(nil), files...)
 	sort.Strings(files)
 	
 	// Create fingerprint from folder name and file list
-	fingerprintData := folderName + "|" + strings.Join(files, "|")
+	fingerprintData := filepath.Base(folderPath) + "|" + strings.Join(files, "|")
 	
 	// Generate SHA256 hash
 	hash := sha256.Sum256([]byte(fingerprintData))
@@ -416,6 +572,12 @@ func (s *Server) generateFolderFingerprint(folderPath string) string {
 	return fingerprint
 }
 
+func (s *Server) invalidateFingerprint(folderPath string) {
+	s.cacheMutex.Lock()
+	delete(s.fingerprintCache, folderPath)
+	s.cacheMutex.Unlock()
+}
+
 func (s *Server) findFolderByFingerprint(targetDirectory, fingerprint string) string {
 	entries, err := os.ReadDir(targetDirectory)
 	if err != nil {
@@ -423,7 +585,8 @@ func (s *Server) findFolderByFingerprint(targetDirectory, fingerprint string) st
 	}
 	
 	for _, entry := range entries {
-		if entry.IsDir() {
+		// Skip the trash and other hidden folders
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
 			folderPath := filepath.Join(targetDirectory, entry.Name())
 			if s.generateFolderFingerprint(folderPath) == fingerprint {
 				return folderPath
@@ -434,97 +597,78 @@ func (s *Server) findFolderByFingerprint(targetDirectory, fingerprint string) st
 	return ""
 }
 
+// checkSyncStatus reports whether sourcePath is synced to targetDirectory.
+// Checking many albums against one target should share a syncIndex.
 func (s *Server) checkSyncStatus(sourcePath, targetDirectory string) bool {
-	sourceFingerprint := s.generateFolderFingerprint(sourcePath)
-	if sourceFingerprint == "" {
-		return false
-	}
-	
-	// Check if a folder with the same fingerprint exists in target directory
-	matchingFolder := s.findFolderByFingerprint(targetDirectory, sourceFingerprint)
-	return matchingFolder != ""
+	return s.newSyncIndex(targetDirectory).synced(sourcePath)
 }
 
-func syncAlbum(sourcePath, targetDirectory string) (string, error) {
-	folderName := filepath.Base(sourcePath)
-	targetPath := filepath.Join(targetDirectory, folderName)
-	
-	// Create target directory
-	if err := os.MkdirAll(targetPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create target directory: %v", err)
+// syncOptions fills in the conflict policy from the settings when the request
+// does not name one, and the device targetDirectory is on.
+func (s *Server) syncOptions(options SyncOptions, targetDirectory string) (SyncOptions, error) {
+	if options.ConflictPolicy == "" {
+		options.ConflictPolicy = s.loadSettings().ConflictPolicy
 	}
-	
-	// Copy all files
-	if err := copyDirectory(sourcePath, targetPath); err != nil {
-		return "", fmt.Errorf("failed to copy files: %v", err)
+	if options.ConflictPolicy == "" {
+		options.ConflictPolicy = conflictRename
 	}
-	
-	return fmt.Sprintf("Successfully synced %s to %s", folderName, targetPath), nil
+	if !validConflictPolicy(options.ConflictPolicy) {
+		return options, fmt.Errorf("unknown conflict policy %q", options.ConflictPolicy)
+	}
+	if options.Device == nil {
+		options.Device = s.targetDevice(targetDirectory)
+	}
+	return options, nil
 }
 
-func unsyncAlbum(targetDirectory, albumName string) (string, error) {
-	targetPath := filepath.Join(targetDirectory, albumName)
+func (s *Server) syncAlbum(sourcePath, targetDirectory string, options SyncOptions) (SyncResult, error) {
+	s.manifestMutex.Lock()
+	defer s.manifestMutex.Unlock()
 	
-	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("album %s not found in target directory", albumName)
+	manifest, err := loadManifest(targetDirectory)
+	if err != nil {
+		return SyncResult{}, err
 	}
 	
-	if err := os.RemoveAll(targetPath); err != nil {
-		return "", fmt.Errorf("failed to remove album: %v", err)
+	plan, err := s.planAlbumSync(targetDirectory, &manifest, sourcePath, options)
+	if err != nil {
+		return SyncResult{Conflict: plan.conflict}, err
 	}
 	
-	return fmt.Sprintf("Successfully removed %s", albumName), nil
-}
-
-func parseArtistAndAlbum(parentFolderName, albumFolderName string) (string, string) {
-	// Strategy 1: Parent folder is artist, album folder is album
-	// Example: "Beatles/Abbey Road/" -> artist: "Beatles", album: "Abbey Road"
-	if parentFolderName != "" && !isRootLevelFolder(parentFolderName) {
-		return parentFolderName, albumFolderName
+	result := SyncResult{
+		Folder:   manifestFolder(plan.folder),
+		Action:   plan.action,
+		Conflict: plan.conflict,
+		DryRun:   options.DryRun,
+		Plan:     &plan.SyncPlan,
 	}
 	
-	// Strategy 2: Album folder contains "Artist - Album" format
-	if idx := strings.Index(albumFolderName, " - "); idx != -1 {
-		artist := strings.TrimSpace(albumFolderName[:idx])
-		album := strings.TrimSpace(albumFolderName[idx+3:])
-		if artist != "" && album != "" {
-			return artist, album
-		}
+	if plan.action == "skip" {
+		result.Folder = ""
+		result.Result = fmt.Sprintf("Skipped %s: target folder holds a different album", filepath.Base(sourcePath))
+		return result, nil
 	}
 	
-	// Strategy 3: Album folder contains "Artist-Album" format (no spaces)
-	if idx := strings.Index(albumFolderName, "-"); idx != -1 {
-		artist := strings.TrimSpace(albumFolderName[:idx])
-		album := strings.TrimSpace(albumFolderName[idx+1:])
-		if artist != "" && album != "" {
-			return artist, album
-		}
+	// Dry runs fail the same way, so they show whether the album fits
+	if err := checkCapacity(targetDirectory, plan.BytesToWrite, options); err != nil {
+		return SyncResult{Conflict: plan.conflict}, err
 	}
 	
-	// Strategy 4: Album folder contains "Artist_Album" format (underscore)
-	if idx := strings.Index(albumFolderName, "_"); idx != -1 {
-		artist := strings.TrimSpace(albumFolderName[:idx])
-		album := strings.TrimSpace(albumFolderName[idx+1:])
-		if artist != "" && album != "" {
-			return artist, album
-		}
+	targetPath := filepath.Join(targetDirectory, plan.folder)
+	if options.DryRun {
+		result.Result = fmt.Sprintf("Would sync %s to %s", filepath.Base(sourcePath), targetPath)
+		return result, nil
 	}
 	
-	// Default: Unknown artist, album folder name as album
-	return "Unknown Artist", albumFolderName
-}
-
-func isRootLevelFolder(folderName string) bool {
-	// Check if this is a root-level folder that shouldn't be treated as an artist
-	rootFolders := []string{"Music", "music", "Songs", "songs", "Audio", "audio", "Media", "media"}
-	
-	for _, root := range rootFolders {
-		if strings.EqualFold(folderName, root) {
-			return true
-		}
+	if err := s.applyAlbumPlan(targetDirectory, &manifest, plan); err != nil {
+		return SyncResult{}, err
+	}
+	if err := saveManifest(targetDirectory, manifest); err != nil {
+		return SyncResult{}, err
 	}
 	
-	return false
+	result.Result = fmt.Sprintf("Successfully synced %s to %s", filepath.Base(sourcePath), targetPath)
+	return result, nil
 }
 
 func calculateFolderSize(path string) float64 {
@@ -551,38 +695,28 @@ func calculateFolderSize(path string) float64 {
 	return float64(size) / 1024 / 1024 // Convert to MB
 }
 
-func copyDirectory(src, dst string) error {
-	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-		
-		dstPath := filepath.Join(dst, relPath)
-		
-		if d.IsDir() {
-			return os.MkdirAll(dstPath, 0755)
-		}
-		
-		srcFile, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer srcFile.Close()
-		
-		dstFile, err := os.Create(dstPath)
-		if err != nil {
-			return err
-		}
-		defer dstFile.Close()
-		
-		_, err = srcFile.WriteTo(dstFile)
+// copyFile copies src to dst, creating dst's folder when needed.
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
 		return err
-	})
+	}
+	
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	
+	if _, err := srcFile.WriteTo(dstFile); err != nil {
+		dstFile.Close()
+		return err
+	}
+	return dstFile.Close()
 }
 
 func browseDirectory(path string) ([]DirectoryItem, error) {
@@ -634,12 +768,24 @@ func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(settings)
 	case http.MethodPost:
-		var settings AppSettings
-		if err := json.NewDecoder(r.Body).Decode(&settings); err \!= nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		// Decode over the stored settings so fields the client does not send
+		// are kept. The allowed roots only change through /api/roots.
+		var decodeErr error
+		err := s.updateSettings(func(settings *AppSettings) error {
+			libraryRoots, targetRoots := settings.LibraryRoots, settings.TargetRoots
+			settings.LibraryRoots, settings.TargetRoots = nil, nil
+			decodeErr = json.NewDecoder(r.Body).Decode(settings)
+			settings.LibraryRoots, settings.TargetRoots = libraryRoots, targetRoots
+			if decodeErr == nil {
+				_, decodeErr = compileLibraryPatterns(settings.LibraryPatterns)
+			}
+			return decodeErr
+		})
+		if decodeErr != nil {
+			http.Error(w, decodeErr.Error(), http.StatusBadRequest)
 			return
 		}
-		if err := s.saveSettings(settings); err \!= nil {
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -654,12 +800,12 @@ func (s *Server) loadSettings() AppSettings {
 	var settings AppSettings
 	
 	data, err := os.ReadFile(s.settingsFile)
-	if err \!= nil {
+	if err != nil {
 		// File does not exist or cannot be read, return empty settings
 		return settings
 	}
 	
-	if err := json.Unmarshal(data, &settings); err \!= nil {
+	if err := json.Unmarshal(data, &settings); err != nil {
 		log.Printf("Warning: Could not parse settings file: %v", err)
 		return AppSettings{} // Return empty settings on parse error
 	}
@@ -667,13 +813,26 @@ func (s *Server) loadSettings() AppSettings {
 	return settings
 }
 
+// updateSettings loads the settings, applies change and saves them, holding
+// settingsMutex so concurrent edits do not overwrite each other.
+func (s *Server) updateSettings(change func(*AppSettings) error) error {
+	s.settingsMutex.Lock()
+	defer s.settingsMutex.Unlock()
+	
+	settings := s.loadSettings()
+	if err := change(&settings); err != nil {
+		return err
+	}
+	return s.saveSettings(settings)
+}
+
 func (s *Server) saveSettings(settings AppSettings) error {
 	data, err := json.MarshalIndent(settings, "", "  ")
-	if err \!= nil {
+	if err != nil {
 		return fmt.Errorf("failed to marshal settings: %v", err)
 	}
 	
-	if err := os.WriteFile(s.settingsFile, data, 0644); err \!= nil {
+	if err := os.WriteFile(s.settingsFile, data, 0644); err != nil {
 		return fmt.Errorf("failed to write settings file: %v", err)
 	}
 	
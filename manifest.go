@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The manifest lives in the root of each target and records the folders
+// music-sync created there, so destructive operations can be limited to them.
+const manifestFileName = ".music-sync.json"
+
+type SyncedAlbum struct {
+	Folder      string    `json:"folder"`
+	SourcePath  string    `json:"sourcePath"`
+	Fingerprint string    `json:"fingerprint"`
+	SyncedAt    time.Time `json:"syncedAt"`
+	// Renamed maps source files to the names they were given on the target
+	// to suit the device, relative to the album folder.
+	Renamed map[string]string `json:"renamed,omitempty"`
+}
+
+type TrashedAlbum struct {
+	SyncedAlbum
+	TrashFolder string    `json:"trashFolder"`
+	TrashedAt   time.Time `json:"trashedAt"`
+}
+
+type TargetManifest struct {
+	Albums []SyncedAlbum  `json:"albums"`
+	Trash  []TrashedAlbum `json:"trash,omitempty"`
+}
+
+func loadManifest(targetDirectory string) (TargetManifest, error) {
+	var manifest TargetManifest
+
+	data, err := os.ReadFile(filepath.Join(targetDirectory, manifestFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	return manifest, nil
+}
+
+// saveManifest writes through a temporary file so an unplugged device never
+// ends up with a half-written manifest.
+func saveManifest(targetDirectory string, manifest TargetManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+
+	manifestPath := filepath.Join(targetDirectory, manifestFileName)
+	tempPath := manifestPath + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+	if err := os.Rename(tempPath, manifestPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	return nil
+}
+
+// manifestFolder is the form folders are stored in, so a manifest written on
+// one OS still matches on another.
+func manifestFolder(folder string) string {
+	return filepath.ToSlash(filepath.Clean(folder))
+}
+
+func (m *TargetManifest) find(folder string) (SyncedAlbum, bool) {
+	folder = manifestFolder(folder)
+	for _, album := range m.Albums {
+		if album.Folder == folder {
+			return album, true
+		}
+	}
+	return SyncedAlbum{}, false
+}
+
+// findBySource returns the entry for an album synced from sourcePath, which
+// may live under a different folder name after a conflict rename.
+func (m *TargetManifest) findBySource(sourcePath string) (SyncedAlbum, bool) {
+	for _, album := range m.Albums {
+		if album.SourcePath == sourcePath {
+			return album, true
+		}
+	}
+	return SyncedAlbum{}, false
+}
+
+// record adds entry, replacing any earlier entry for the same folder.
+func (m *TargetManifest) record(entry SyncedAlbum) {
+	entry.Folder = manifestFolder(entry.Folder)
+	m.remove(entry.Folder)
+	m.Albums = append(m.Albums, entry)
+}
+
+func (m *TargetManifest) remove(folder string) {
+	folder = manifestFolder(folder)
+	albums := m.Albums[:0]
+	for _, album := range m.Albums {
+		if album.Folder != folder {
+			albums = append(albums, album)
+		}
+	}
+	m.Albums = albums
+}
+
+// targetFolderPath checks that folder names a place inside targetDirectory
+// (no absolute paths, "..", hidden or symlinked escapes) and returns its path.
+func targetFolderPath(targetDirectory, folder string) (string, error) {
+	if folder == "" || !filepath.IsLocal(folder) {
+		return "", fmt.Errorf("invalid album folder %q", folder)
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(folder), "/") {
+		if strings.HasPrefix(part, ".") {
+			return "", fmt.Errorf("invalid album folder %q", folder)
+		}
+	}
+
+	folderPath, err := resolvePath(filepath.Join(targetDirectory, folder))
+	if err != nil {
+		return "", err
+	}
+	root, err := resolvePath(targetDirectory)
+	if err != nil {
+		return "", err
+	}
+	if folderPath == root || !isWithin(root, folderPath) {
+		return "", fmt.Errorf("album folder %q is outside the target directory", folder)
+	}
+
+	return folderPath, nil
+}
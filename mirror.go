@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+type MirrorAction struct {
+	SourcePath string        `json:"source_path,omitempty"`
+	Folder     string        `json:"folder"`
+	SizeMB     float64       `json:"size_mb"`
+	Conflict   *NameConflict `json:"conflict,omitempty"`
+}
+
+// MirrorPlan lists what has to happen for a target to hold exactly the
+// selected albums. Unknown folders were not created by music-sync and are
+// never touched.
+type MirrorPlan struct {
+	Add     []MirrorAction `json:"add"`
+	Update  []MirrorAction `json:"update"`
+	Remove  []MirrorAction `json:"remove"`
+	Keep    []MirrorAction `json:"keep"`
+	Skip    []MirrorAction `json:"skip"`
+	Unknown []string       `json:"unknown"`
+	Files   SyncPlan       `json:"files"`
+}
+
+// errEmptyMirror refuses to mirror nothing, which would remove every album
+// music-sync put on the target.
+var errEmptyMirror = errors.New("a mirror needs at least one album; unsync albums to remove them")
+
+type MirrorResult struct {
+	Plan    MirrorPlan `json:"plan"`
+	DryRun  bool       `json:"dryRun"`
+	Results []string   `json:"results,omitempty"`
+	Errors  []string   `json:"errors,omitempty"`
+}
+
+// planMirror compares the selection with what music-sync has put on the
+// target so far.
+func (s *Server) planMirror(sourcePaths []string, targetDirectory string, options SyncOptions) (MirrorPlan, error) {
+	plan := MirrorPlan{
+		Add:     []MirrorAction{},
+		Update:  []MirrorAction{},
+		Remove:  []MirrorAction{},
+		Keep:    []MirrorAction{},
+		Skip:    []MirrorAction{},
+		Unknown: []string{},
+		Files:   newSyncPlan(),
+	}
+
+	s.manifestMutex.Lock()
+	defer s.manifestMutex.Unlock()
+
+	manifest, err := loadManifest(targetDirectory)
+	if err != nil {
+		return plan, err
+	}
+
+	// Only the folder names are needed here, not the inventory's file counts
+	// and durations, which would read every audio file on the device
+	onTarget := make(map[string]string)
+	err = walkTargetAlbums(targetDirectory, manifest, func(path, folder string, entry *SyncedAlbum) {
+		if entry == nil {
+			plan.Unknown = append(plan.Unknown, folder)
+			return
+		}
+		onTarget[folder] = path
+	})
+	if err != nil {
+		return plan, err
+	}
+
+	selected := make(map[string]bool)
+	for _, sourcePath := range sourcePaths {
+		if selected[sourcePath] {
+			continue
+		}
+		selected[sourcePath] = true
+
+		if entry, ok := manifest.findBySource(sourcePath); ok {
+			if folderPath, ok := onTarget[entry.Folder]; ok {
+				action := MirrorAction{SourcePath: sourcePath, Folder: entry.Folder, SizeMB: calculateFolderSize(sourcePath)}
+				if s.managedFolderStatus(entry, folderPath, "") == statusSynced {
+					plan.Keep = append(plan.Keep, action)
+				} else {
+					plan.Update = append(plan.Update, action)
+				}
+				continue
+			}
+		}
+
+		layout, _ := s.layoutFolder(sourcePath, options)
+		folder, resolved, conflict, err := s.resolveTargetFolder(targetDirectory, &manifest, sourcePath, layout, options)
+		action := MirrorAction{
+			SourcePath: sourcePath,
+			Folder:     manifestFolder(folder),
+			SizeMB:     calculateFolderSize(sourcePath),
+			Conflict:   conflict,
+		}
+		switch {
+		case errors.Is(err, errConfirmationRequired), resolved == "skip":
+			action.Folder = manifestFolder(layout)
+			plan.Skip = append(plan.Skip, action)
+		case err != nil:
+			return plan, err
+		case resolved == "update":
+			// An identical copy from before the manifest, record it as ours
+			plan.Update = append(plan.Update, action)
+		default:
+			plan.Add = append(plan.Add, action)
+		}
+	}
+
+	// Everything else music-sync put there goes
+	for _, entry := range manifest.Albums {
+		folderPath, ok := onTarget[entry.Folder]
+		if !ok || selected[entry.SourcePath] {
+			continue
+		}
+
+		trashed, err := trashOps(targetDirectory, folderPath)
+		if err != nil {
+			return plan, err
+		}
+		var size int64
+		for _, op := range trashed {
+			size += op.Bytes
+		}
+		plan.Remove = append(plan.Remove, MirrorAction{
+			SourcePath: entry.SourcePath,
+			Folder:     entry.Folder,
+			SizeMB:     float64(size) / 1024 / 1024,
+		})
+		plan.Files.Deletes = append(plan.Files.Deletes, trashed...)
+	}
+
+	for _, action := range append(plan.Update, plan.Add...) {
+		albumPlan, err := s.planAlbumSync(targetDirectory, &manifest, action.SourcePath, options)
+		if err != nil {
+			return plan, err
+		}
+		plan.Files.merge(albumPlan.SyncPlan)
+	}
+
+	return plan, nil
+}
+
+// mirror makes the target hold exactly the selected albums. Removals run
+// first and only ever touch folders from the manifest, but they move them to
+// the trash on the same volume, so they free no space until it is purged and
+// the capacity check does not count them.
+func (s *Server) mirror(sourcePaths []string, targetDirectory string, options SyncOptions) (MirrorResult, error) {
+	if len(sourcePaths) == 0 && !options.DryRun {
+		return MirrorResult{}, errEmptyMirror
+	}
+
+	plan, err := s.planMirror(sourcePaths, targetDirectory, options)
+	if err != nil {
+		return MirrorResult{}, err
+	}
+	if err := checkCapacity(targetDirectory, plan.Files.BytesToWrite, options); err != nil {
+		return MirrorResult{Plan: plan, DryRun: options.DryRun}, err
+	}
+
+	result := MirrorResult{Plan: plan, DryRun: options.DryRun}
+	if options.DryRun {
+		return result, nil
+	}
+
+	for _, action := range plan.Remove {
+		if _, _, err := s.unsyncAlbum(targetDirectory, filepath.FromSlash(action.Folder), "", false, false); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Error removing %s: %v", action.Folder, err))
+			continue
+		}
+		result.Results = append(result.Results, fmt.Sprintf("Removed %s", action.Folder))
+	}
+
+	for _, action := range append(plan.Update, plan.Add...) {
+		synced, err := s.syncAlbum(action.SourcePath, targetDirectory, options)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Error syncing %s: %v", action.SourcePath, err))
+			continue
+		}
+		result.Results = append(result.Results, synced.Result)
+	}
+
+	return result, nil
+}
+
+func (s *Server) handleMirror(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		SourcePaths     []string `json:"sourcePaths"`
+		TargetDirectory string   `json:"targetDirectory"`
+		SyncOptions
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	targetDirectory, err := resolveAllowed(req.TargetDirectory, s.targetRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+
+	libraryRoots := s.libraryRoots()
+	var sourcePaths []string
+	for _, sourcePath := range req.SourcePaths {
+		resolved, err := resolveAllowed(sourcePath, libraryRoots)
+		if err != nil {
+			writePathError(w, err)
+			return
+		}
+		if _, err := os.Stat(resolved); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sourcePaths = append(sourcePaths, resolved)
+	}
+
+	options, err := s.syncOptions(req.SyncOptions, targetDirectory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.mirror(sourcePaths, targetDirectory, options)
+	if errors.Is(err, errEmptyMirror) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errInsufficientSpace) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "mirror_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	targetDir := filepath.Join(tempDir, "target")
+	keep := filepath.Join(library, "Artist", "Keep")
+	drop := filepath.Join(library, "Artist", "Drop")
+	changed := filepath.Join(library, "Artist", "Changed")
+	added := filepath.Join(library, "Artist", "Added")
+	for _, album := range []string{keep, drop, changed, added} {
+		createAlbum(t, album, []string{"01.mp3"})
+	}
+	createAlbum(t, filepath.Join(targetDir, "Manual"), []string{"01.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	for _, album := range []string{keep, drop, changed} {
+		if _, err := server.syncAlbum(album, targetDir, SyncOptions{}); err != nil {
+			t.Fatalf("Sync of %s failed: %v", album, err)
+		}
+	}
+
+	// A track was replaced in the source since the last sync
+	os.Remove(filepath.Join(changed, "01.mp3"))
+	createAlbum(t, changed, []string{"01 (remaster).mp3"})
+	server.invalidateFingerprint(changed)
+
+	selection := []string{keep, changed, added}
+
+	result, err := server.mirror(selection, targetDir, SyncOptions{ConflictPolicy: conflictRename, DryRun: true})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	plan := result.Plan
+	if len(plan.Add) != 1 || plan.Add[0].SourcePath != added {
+		t.Errorf("Expected Added to be added, got %+v", plan.Add)
+	}
+	if len(plan.Update) != 1 || plan.Update[0].SourcePath != changed {
+		t.Errorf("Expected Changed to be updated, got %+v", plan.Update)
+	}
+	if len(plan.Remove) != 1 || plan.Remove[0].Folder != "Drop" {
+		t.Errorf("Expected Drop to be removed, got %+v", plan.Remove)
+	}
+	if len(plan.Keep) != 1 || plan.Keep[0].Folder != "Keep" {
+		t.Errorf("Expected Keep to be kept, got %+v", plan.Keep)
+	}
+	if len(plan.Unknown) != 1 || plan.Unknown[0] != "Manual" {
+		t.Errorf("Expected Manual to be reported as unknown, got %+v", plan.Unknown)
+	}
+
+	// A dry run does not touch the target
+	if _, err := os.Stat(filepath.Join(targetDir, "Drop")); err != nil {
+		t.Errorf("Expected dry run to leave Drop in place: %v", err)
+	}
+
+	result, err = server.mirror(selection, targetDir, SyncOptions{ConflictPolicy: conflictRename})
+	if err != nil {
+		t.Fatalf("Mirror failed: %v", err)
+	}
+	if len(result.Errors) != 0 {
+		t.Fatalf("Expected no errors, got %v", result.Errors)
+	}
+
+	// The target now holds exactly the selection, plus the unknown folder
+	inventory, err := server.targetInventory(targetDir, library)
+	if err != nil {
+		t.Fatalf("Inventory failed: %v", err)
+	}
+	if inventory.Counts[statusSynced] != 3 || inventory.Counts[statusUnknown] != 1 || len(inventory.Folders) != 4 {
+		t.Errorf("Expected 3 synced and 1 unknown folder, got %+v", inventory.Folders)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "Changed", "01.mp3")); !os.IsNotExist(err) {
+		t.Error("Expected replaced track to be removed from the updated album")
+	}
+}
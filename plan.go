@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileOp is a single file a sync would write or remove. Paths are relative to
+// the target directory and use forward slashes.
+type FileOp struct {
+	Path       string `json:"path"`
+	SourcePath string `json:"source_path,omitempty"`
+	Bytes      int64  `json:"bytes"`
+	Trash      bool   `json:"trash,omitempty"`
+}
+
+type Rename struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+type Violation struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// SyncPlan is the file-level description of a sync, unsync or mirror. Dry
+// runs return it without touching the target; real runs execute it.
+type SyncPlan struct {
+	Creates      []FileOp    `json:"creates"`
+	Overwrites   []FileOp    `json:"overwrites"`
+	Deletes      []FileOp    `json:"deletes"`
+	Renames      []Rename    `json:"renames"`
+	Violations   []Violation `json:"violations"`
+	BytesToWrite int64       `json:"bytes_to_write"`
+}
+
+func newSyncPlan() SyncPlan {
+	return SyncPlan{
+		Creates:    []FileOp{},
+		Overwrites: []FileOp{},
+		Deletes:    []FileOp{},
+		Renames:    []Rename{},
+		Violations: []Violation{},
+	}
+}
+
+func (p *SyncPlan) merge(other SyncPlan) {
+	p.Creates = append(p.Creates, other.Creates...)
+	p.Overwrites = append(p.Overwrites, other.Overwrites...)
+	p.Deletes = append(p.Deletes, other.Deletes...)
+	p.Renames = append(p.Renames, other.Renames...)
+	p.Violations = append(p.Violations, other.Violations...)
+	p.BytesToWrite += other.BytesToWrite
+}
+
+var errInsufficientSpace = errors.New("not enough space on the target")
+
+// checkCapacity fails when writing bytes to targetDirectory would leave less
+// free space than the device's capacity reserve. Space freed by overwrites is
+// not counted, and removals only move folders to the trash on the same
+// filesystem. Filesystems whose free space cannot be read are not checked.
+func checkCapacity(targetDirectory string, bytes int64, options SyncOptions) error {
+	if bytes <= 0 {
+		return nil
+	}
+	_, free, err := diskSpace(targetDirectory)
+	if err != nil {
+		return nil
+	}
+	var reserve uint64
+	if options.Device != nil {
+		reserve = uint64(options.Device.CapacityReserveMB * 1024 * 1024)
+	}
+	if uint64(bytes)+reserve > free {
+		return fmt.Errorf("%w: %.1f MB to write, %.1f MB free, %.1f MB kept in reserve", errInsufficientSpace,
+			float64(bytes)/1024/1024, float64(free)/1024/1024, float64(reserve)/1024/1024)
+	}
+	return nil
+}
+
+// albumPlan is a SyncPlan for one album together with the folder decision it
+// was built from.
+type albumPlan struct {
+	SyncPlan
+	sourcePath string
+	folder     string
+	action     string
+	conflict   *NameConflict
+	// renamed maps source files to the names the device's filename rules
+	// gave them on the target, both relative to the album and slash-separated.
+	renamed map[string]string
+}
+
+// planAlbumSync works out what syncing sourcePath would do. Files already on
+// the target with the same size and a modification time no older than the
+// source are left alone. The caller must hold manifestMutex.
+func (s *Server) planAlbumSync(targetDirectory string, manifest *TargetManifest, sourcePath string, options SyncOptions) (albumPlan, error) {
+	plan := albumPlan{SyncPlan: newSyncPlan(), sourcePath: sourcePath, renamed: make(map[string]string)}
+
+	layout, layoutRename := s.layoutFolder(sourcePath, options)
+	folder, action, conflict, err := s.resolveTargetFolder(targetDirectory, manifest, sourcePath, layout, options)
+	plan.folder, plan.action, plan.conflict = folder, action, conflict
+	if err != nil {
+		return plan, fmt.Errorf("%s already holds a different album: %w", conflict.Folder, err)
+	}
+	if action == "skip" {
+		return plan, nil
+	}
+
+	if layoutRename != nil {
+		plan.Renames = append(plan.Renames, *layoutRename)
+	}
+	if action == "rename" {
+		plan.Renames = append(plan.Renames, Rename{
+			From:   manifestFolder(conflict.Folder),
+			To:     manifestFolder(folder),
+			Reason: fmt.Sprintf("target folder %s holds a different album", conflict.Folder),
+		})
+	}
+
+	targetPath := filepath.Join(targetDirectory, folder)
+
+	// The album being replaced goes to the trash as a whole
+	if action == "overwrite" {
+		trashed, err := trashOps(targetDirectory, targetPath)
+		if err != nil {
+			return plan, err
+		}
+		plan.Deletes = append(plan.Deletes, trashed...)
+	}
+
+	// Files are written under names the device can store; targets are
+	// relative to the album folder, like the source paths they come from
+	rules := options.filenameRules()
+	wanted := make(map[string]bool)
+	reported := make(map[string]bool)
+	err = filepath.WalkDir(sourcePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		sourceRel, err := filepath.Rel(sourcePath, path)
+		if err != nil {
+			return err
+		}
+		cleaned, reason := rules.cleanPath(filepath.ToSlash(sourceRel))
+		for n, base := 2, cleaned; wanted[filepath.FromSlash(cleaned)]; n++ {
+			cleaned = numberedName(base, n)
+			reason = "another file has the same name on the device"
+		}
+		relPath := filepath.FromSlash(cleaned)
+		wanted[relPath] = true
+		if cleaned != filepath.ToSlash(sourceRel) {
+			plan.renamed[filepath.ToSlash(sourceRel)] = cleaned
+			plan.Renames = append(plan.Renames, Rename{
+				From:   manifestFolder(filepath.Join(folder, sourceRel)),
+				To:     manifestFolder(filepath.Join(folder, relPath)),
+				Reason: reason,
+			})
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		op := FileOp{
+			Path:       manifestFolder(filepath.Join(folder, relPath)),
+			SourcePath: path,
+			Bytes:      info.Size(),
+		}
+		for _, violation := range nameViolations(op.Path) {
+			if !reported[violation.Path] {
+				reported[violation.Path] = true
+				plan.Violations = append(plan.Violations, violation)
+			}
+		}
+
+		if action != "overwrite" {
+			if existing, err := os.Stat(filepath.Join(targetPath, relPath)); err == nil {
+				if existing.Size() == info.Size() && !existing.ModTime().Before(info.ModTime()) {
+					return nil // Unchanged
+				}
+				plan.Overwrites = append(plan.Overwrites, op)
+				plan.BytesToWrite += op.Bytes
+				return nil
+			}
+		}
+
+		plan.Creates = append(plan.Creates, op)
+		plan.BytesToWrite += op.Bytes
+		return nil
+	})
+	if err != nil {
+		return plan, fmt.Errorf("failed to read source album: %v", err)
+	}
+
+	// The folder is ours, so files that are no longer in the source go. A
+	// folder adopted because its fingerprint matched was not written by
+	// music-sync, so only files the fingerprint covers (those at the top and
+	// in disc folders) may go; scans and extras elsewhere stay.
+	if action == "update" {
+		_, managed := manifest.find(folder)
+		covered := make(map[string]bool)
+		if !managed {
+			files, err := albumFiles(targetPath)
+			if err != nil {
+				return plan, fmt.Errorf("failed to read target album: %v", err)
+			}
+			for _, file := range files {
+				covered[filepath.FromSlash(file)] = true
+			}
+		}
+
+		err = filepath.WalkDir(targetPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			relPath, err := filepath.Rel(targetPath, path)
+			if err != nil {
+				return err
+			}
+			if wanted[relPath] || (!managed && !covered[relPath]) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			plan.Deletes = append(plan.Deletes, FileOp{
+				Path:  manifestFolder(filepath.Join(folder, relPath)),
+				Bytes: info.Size(),
+			})
+			return nil
+		})
+		if err != nil {
+			return plan, fmt.Errorf("failed to read target album: %v", err)
+		}
+	}
+
+	return plan, nil
+}
+
+// applyAlbumPlan carries out plan and records the album in the manifest. The
+// caller must hold manifestMutex and save the manifest.
+func (s *Server) applyAlbumPlan(targetDirectory string, manifest *TargetManifest, plan albumPlan) error {
+	targetPath := filepath.Join(targetDirectory, plan.folder)
+
+	// Keep the album being replaced in the trash rather than merging into it
+	if plan.action == "overwrite" {
+		existing, _ := manifest.find(plan.folder)
+		existing.Folder = plan.folder
+		if _, err := s.moveToTrash(targetDirectory, manifest, existing, targetPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(targetPath, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %v", err)
+	}
+
+	for _, op := range append(plan.Creates, plan.Overwrites...) {
+		if err := copyFile(op.SourcePath, filepath.Join(targetDirectory, filepath.FromSlash(op.Path))); err != nil {
+			return fmt.Errorf("failed to copy files: %v", err)
+		}
+	}
+
+	for _, op := range plan.Deletes {
+		if op.Trash {
+			continue // Moved along with the folder above
+		}
+		if err := os.Remove(filepath.Join(targetDirectory, filepath.FromSlash(op.Path))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old files: %v", err)
+		}
+	}
+	removeEmptyDirs(targetPath)
+	s.invalidateFingerprint(targetPath)
+
+	// Record the folder so unsync knows music-sync owns it
+	entry := SyncedAlbum{
+		Folder:      plan.folder,
+		SourcePath:  plan.sourcePath,
+		Fingerprint: s.generateFolderFingerprint(plan.sourcePath),
+		SyncedAt:    time.Now(),
+	}
+	if len(plan.renamed) > 0 {
+		entry.Renamed = plan.renamed
+	}
+	manifest.record(entry)
+
+	return nil
+}
+
+// trashOps lists the files under folderPath as moves to the trash.
+func trashOps(targetDirectory, folderPath string) ([]FileOp, error) {
+	var ops []FileOp
+	err := filepath.WalkDir(folderPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(targetDirectory, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		ops = append(ops, FileOp{Path: manifestFolder(relPath), Bytes: info.Size(), Trash: true})
+		return nil
+	})
+	return ops, err
+}
+
+// removeEmptyDirs removes folders below root left empty after deletes.
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && path != root {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+
+	// Deepest first, so parents empty out after their children
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		os.Remove(dir) // Fails harmlessly when not empty
+	}
+}
+
+// Characters FAT32 and exFAT cannot store in a file name, which is what most
+// players and USB sticks use.
+const fatInvalidChars = `<>:"\|?*`
+
+// nameViolations reports the parts of path that would not be valid on a
+// FAT32 or exFAT device, each under the path up to the offending part.
+func nameViolations(path string) []Violation {
+	var violations []Violation
+
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		var reason string
+		switch {
+		case strings.ContainsAny(part, fatInvalidChars):
+			reason = fmt.Sprintf("name contains one of %s", fatInvalidChars)
+		case strings.IndexFunc(part, func(r rune) bool { return r < 0x20 }) != -1:
+			reason = "name contains control characters"
+		case strings.HasSuffix(part, ".") || strings.HasSuffix(part, " "):
+			reason = "name ends with a dot or space"
+		case len(part) > 255:
+			reason = "name is longer than 255 bytes"
+		}
+		if reason != "" {
+			violations = append(violations, Violation{Path: strings.Join(parts[:i+1], "/"), Reason: reason})
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncDryRunPlan(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "plan_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sourceAlbum := filepath.Join(tempDir, "source", "Artist", "Album")
+	targetDir := filepath.Join(tempDir, "target")
+	createAlbum(t, sourceAlbum, []string{"01.mp3", "02.mp3", "What?.mp3"})
+	createAlbum(t, targetDir, nil)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	result, err := server.syncAlbum(sourceAlbum, targetDir, SyncOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if len(result.Plan.Creates) != 3 || len(result.Plan.Overwrites) != 0 || len(result.Plan.Deletes) != 0 {
+		t.Errorf("Expected 3 creates, got %+v", result.Plan)
+	}
+	if len(result.Plan.Violations) != 1 || result.Plan.Violations[0].Path != "Album/What?.mp3" {
+		t.Errorf("Expected a violation for the FAT-invalid name, got %+v", result.Plan.Violations)
+	}
+
+	// Nothing was written, not even the manifest
+	if entries, _ := os.ReadDir(targetDir); len(entries) != 0 {
+		t.Errorf("Expected dry run to leave the target empty, got %d entries", len(entries))
+	}
+
+	if _, err := server.syncAlbum(sourceAlbum, targetDir, SyncOptions{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// Change one track, drop another and add a new one
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(filepath.Join(sourceAlbum, "01.mp3"), []byte("remastered"), 0644); err != nil {
+		t.Fatalf("Failed to update track: %v", err)
+	}
+	os.Chtimes(filepath.Join(sourceAlbum, "01.mp3"), later, later)
+	os.Remove(filepath.Join(sourceAlbum, "02.mp3"))
+	createAlbum(t, sourceAlbum, []string{"03.mp3"})
+
+	result, err = server.syncAlbum(sourceAlbum, targetDir, SyncOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	plan := result.Plan
+	if len(plan.Creates) != 1 || plan.Creates[0].Path != "Album/03.mp3" {
+		t.Errorf("Expected 03.mp3 to be created, got %+v", plan.Creates)
+	}
+	if len(plan.Overwrites) != 1 || plan.Overwrites[0].Path != "Album/01.mp3" {
+		t.Errorf("Expected 01.mp3 to be overwritten, got %+v", plan.Overwrites)
+	}
+	if len(plan.Deletes) != 1 || plan.Deletes[0].Path != "Album/02.mp3" {
+		t.Errorf("Expected 02.mp3 to be deleted, got %+v", plan.Deletes)
+	}
+	if plan.BytesToWrite != int64(len("remastered")) {
+		t.Errorf("Expected %d bytes to write, got %d", len("remastered"), plan.BytesToWrite)
+	}
+
+	// Unsync dry runs list the files that would go to the trash
+	_, unsyncPlan, err := server.unsyncAlbum(targetDir, "Album", "", false, true)
+	if err != nil {
+		t.Fatalf("Unsync dry run failed: %v", err)
+	}
+	if len(unsyncPlan.Deletes) != 3 || !unsyncPlan.Deletes[0].Trash {
+		t.Errorf("Expected 3 files moved to trash, got %+v", unsyncPlan.Deletes)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "Album", "02.mp3")); err != nil {
+		t.Errorf("Expected dry run to leave the album in place: %v", err)
+	}
+}
+
+func TestSyncKeepsExtrasInAdoptedFolder(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "plan_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sourceAlbum := filepath.Join(tempDir, "source", "Artist", "Album")
+	targetDir := filepath.Join(tempDir, "target")
+	createAlbum(t, sourceAlbum, []string{"01.mp3", "cover.jpg"})
+
+	// A copy made before manifests, with scans music-sync never wrote
+	createAlbum(t, filepath.Join(targetDir, "Album"), []string{"01.mp3", "cover.jpg"})
+	createAlbum(t, filepath.Join(targetDir, "Album", "Scans"), []string{"front.jpg"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	result, err := server.syncAlbum(sourceAlbum, targetDir, SyncOptions{})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if result.Action != "update" || len(result.Plan.Deletes) != 0 {
+		t.Errorf("Expected the copy to be adopted without deletes, got %s with %+v", result.Action, result.Plan.Deletes)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "Album", "Scans", "front.jpg")); err != nil {
+		t.Errorf("Expected the scans to stay: %v", err)
+	}
+}
+
+func TestSyncCleansNamesForDevice(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "plan_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sourceAlbum := filepath.Join(tempDir, "source", "Björk", "Vespertine: Live?")
+	targetDir := filepath.Join(tempDir, "target")
+	createAlbum(t, sourceAlbum, []string{"01 Hidden Place.mp3", "02 Cocoon?.mp3", "02 Cocoon_.mp3", "03 Pagan Poetry."})
+	createAlbum(t, targetDir, nil)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+	device := &DeviceProfile{Name: "Player", FilenameRules: FilenameRules{FATSafe: true, ASCIIOnly: true}}
+	options := SyncOptions{Device: device}
+
+	options.DryRun = true
+	dryRun, err := server.syncAlbum(sourceAlbum, targetDir, options)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if dryRun.Folder != "Vespertine_ Live_" {
+		t.Errorf("Expected a FAT-safe folder, got %q", dryRun.Folder)
+	}
+	renames := make(map[string]string)
+	for _, rename := range dryRun.Plan.Renames {
+		if rename.Reason == "" {
+			t.Errorf("Expected a reason for %+v", rename)
+		}
+		renames[rename.From] = rename.To
+	}
+	expected := map[string]string{
+		"Vespertine: Live?":                  "Vespertine_ Live_",
+		"Vespertine_ Live_/02 Cocoon?.mp3":   "Vespertine_ Live_/02 Cocoon_.mp3",
+		"Vespertine_ Live_/02 Cocoon_.mp3":   "Vespertine_ Live_/02 Cocoon_ (2).mp3",
+		"Vespertine_ Live_/03 Pagan Poetry.": "Vespertine_ Live_/03 Pagan Poetry",
+	}
+	if len(renames) != len(expected) {
+		t.Errorf("Expected %d renames, got %+v", len(expected), dryRun.Plan.Renames)
+	}
+	for from, to := range expected {
+		if renames[from] != to {
+			t.Errorf("Expected %s renamed to %s, got %q", from, to, renames[from])
+		}
+	}
+	if len(dryRun.Plan.Violations) != 0 {
+		t.Errorf("Expected no violations once names are cleaned, got %+v", dryRun.Plan.Violations)
+	}
+
+	// The real run writes what the dry run listed
+	options.DryRun = false
+	result, err := server.syncAlbum(sourceAlbum, targetDir, options)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if len(result.Plan.Creates) != len(dryRun.Plan.Creates) {
+		t.Fatalf("Expected the dry run's %d creates, got %+v", len(dryRun.Plan.Creates), result.Plan.Creates)
+	}
+	for i, op := range result.Plan.Creates {
+		if op.Path != dryRun.Plan.Creates[i].Path {
+			t.Errorf("Expected %s as in the dry run, got %s", dryRun.Plan.Creates[i].Path, op.Path)
+		}
+		if _, err := os.Stat(filepath.Join(targetDir, filepath.FromSlash(op.Path))); err != nil {
+			t.Errorf("Expected %s on the target: %v", op.Path, err)
+		}
+	}
+
+	// The renamed copy still counts as synced, and syncing again does nothing
+	inventory, err := server.targetInventory(targetDir, "")
+	if err != nil {
+		t.Fatalf("Failed to read inventory: %v", err)
+	}
+	if len(inventory.Folders) != 1 || inventory.Folders[0].Status != statusSynced {
+		t.Errorf("Expected the renamed copy to be synced, got %+v", inventory.Folders)
+	}
+	options.DryRun = true
+	again, err := server.syncAlbum(sourceAlbum, targetDir, options)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if again.Action != "update" || len(again.Plan.Creates)+len(again.Plan.Overwrites)+len(again.Plan.Deletes) != 0 {
+		t.Errorf("Expected nothing left to do, got %s %+v", again.Action, again.Plan)
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	tests := []struct {
+		rules    FilenameRules
+		name     string
+		expected string
+	}{
+		{FilenameRules{}, "What?.mp3", "What?.mp3"},
+		{FilenameRules{FATSafe: true}, "AC-DC: Live?", "AC-DC_ Live_"},
+		{FilenameRules{FATSafe: true, Replacement: "-"}, "Who*. ", "Who-"},
+		{FilenameRules{ASCIIOnly: true}, "Sigur Rós – Ágætis byrjun", "Sigur Ros - Agaetis byrjun"},
+		{FilenameRules{ASCIIOnly: true}, "坂本龍一", "____"},
+		{FilenameRules{MaxLength: 10}, "01 A Very Long Title.flac", "01 A .flac"},
+	}
+	for _, test := range tests {
+		if cleaned, _ := test.rules.cleanName(test.name); cleaned != test.expected {
+			t.Errorf("Expected %q cleaned to %q, got %q", test.name, test.expected, cleaned)
+		}
+	}
+}
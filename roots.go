@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var errPathNotAllowed = errors.New("path is outside the allowed library and target roots")
+
+// resolvePath returns the absolute, cleaned form of path with symlinks resolved.
+// Paths that do not exist yet are resolved through their deepest existing
+// ancestor, so a target folder can be checked before it is created.
+func resolvePath(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("path required")
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := filepath.EvalSymlinks(absPath)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	parent := filepath.Dir(absPath)
+	if parent == absPath {
+		return absPath, nil
+	}
+
+	resolvedParent, err := resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(absPath)), nil
+}
+
+// isWithin reports whether path is root itself or lies below it. Both paths
+// are expected to be resolved already.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return !filepath.IsAbs(rel)
+}
+
+// defaultRoots is used when the settings file does not list any roots: the
+// user's home directory plus the usual mount locations for removable drives.
+func defaultRoots() []string {
+	var roots []string
+
+	if home, err := os.UserHomeDir(); err == nil {
+		roots = append(roots, home)
+	}
+
+	if runtime.GOOS == "windows" {
+		systemDrive := strings.ToUpper(os.Getenv("SystemDrive"))
+		for _, drive := range getDrives() {
+			if systemDrive != "" && strings.HasPrefix(strings.ToUpper(drive), systemDrive) {
+				continue
+			}
+			roots = append(roots, drive)
+		}
+		return roots
+	}
+
+	for _, mountRoot := range []string{"/media", "/run/media", "/mnt", "/Volumes"} {
+		if _, err := os.Stat(mountRoot); err == nil {
+			roots = append(roots, mountRoot)
+		}
+	}
+
+	return roots
+}
+
+// resolveRoots resolves each configured root, dropping the ones that cannot be
+// resolved, and falls back to defaultRoots when none are configured.
+func resolveRoots(configured []string) []string {
+	if len(configured) == 0 {
+		configured = defaultRoots()
+	}
+
+	var roots []string
+	for _, root := range configured {
+		resolved, err := resolvePath(root)
+		if err != nil {
+			continue
+		}
+		roots = append(roots, resolved)
+	}
+	return roots
+}
+
+func (s *Server) libraryRoots() []string {
+	return resolveRoots(s.loadSettings().LibraryRoots)
+}
+
+func (s *Server) targetRoots() []string {
+	return resolveRoots(s.loadSettings().TargetRoots)
+}
+
+// allowedRoots is the union of the library and target roots, used by the
+// directory browser which picks both kinds of folder.
+func (s *Server) allowedRoots() []string {
+	settings := s.loadSettings()
+	roots := resolveRoots(settings.LibraryRoots)
+	for _, root := range resolveRoots(settings.TargetRoots) {
+		if !containsString(roots, root) {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// resolveAllowed resolves path and checks it against roots, returning the
+// resolved path that handlers should use from then on.
+func resolveAllowed(path string, roots []string) (string, error) {
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid path %q: %v", path, err)
+	}
+
+	for _, root := range roots {
+		if isWithin(root, resolved) {
+			return resolved, nil
+		}
+	}
+
+	return "", errPathNotAllowed
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// writePathError maps path validation failures to an HTTP status.
+func writePathError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPathNotAllowed) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// validRoots resolves configured roots for saving. Each must be an existing
+// directory other than the filesystem root, so a root cannot open up the
+// whole disk.
+func validRoots(configured []string) ([]string, error) {
+	var roots []string
+	for _, root := range configured {
+		resolved, err := resolvePath(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root %q: %v", root, err)
+		}
+		info, err := os.Stat(resolved)
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("root %q is not a directory", root)
+		}
+		if filepath.Dir(resolved) == resolved {
+			return nil, fmt.Errorf("root %q is the whole filesystem", root)
+		}
+		if !containsString(roots, resolved) {
+			roots = append(roots, resolved)
+		}
+	}
+	return roots, nil
+}
+
+// handleRoots reads and replaces the allowed library and target roots. It is
+// the only way to change them over the API; empty lists restore the defaults.
+func (s *Server) handleRoots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		settings := s.loadSettings()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{
+			"libraryRoots": settings.LibraryRoots,
+			"targetRoots":  settings.TargetRoots,
+		})
+	case http.MethodPut:
+		var req struct {
+			LibraryRoots []string `json:"libraryRoots"`
+			TargetRoots  []string `json:"targetRoots"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		libraryRoots, err := validRoots(req.LibraryRoots)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		targetRoots, err := validRoots(req.TargetRoots)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = s.updateSettings(func(settings *AppSettings) error {
+			settings.LibraryRoots, settings.TargetRoots = libraryRoots, targetRoots
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "saved"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
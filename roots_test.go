@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolvePathMissingChild(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "resolve_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	resolvedTemp, err := filepath.EvalSymlinks(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+
+	// A folder that does not exist yet resolves through its existing parent
+	resolved, err := resolvePath(filepath.Join(tempDir, "new", "album"))
+	if err != nil {
+		t.Fatalf("Expected missing path to resolve, got %v", err)
+	}
+	expected := filepath.Join(resolvedTemp, "new", "album")
+	if resolved != expected {
+		t.Errorf("Expected %s, got %s", expected, resolved)
+	}
+
+	// Dot segments are cleaned before the check
+	resolved, err = resolvePath(filepath.Join(tempDir, "new", "..", "..", filepath.Base(tempDir)))
+	if err != nil {
+		t.Fatalf("Expected path to resolve, got %v", err)
+	}
+	if resolved != resolvedTemp {
+		t.Errorf("Expected %s, got %s", resolvedTemp, resolved)
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "music")
+
+	cases := []struct {
+		path     string
+		expected bool
+	}{
+		{root, true},
+		{filepath.Join(root, "Artist", "Album"), true},
+		{filepath.Join(string(filepath.Separator), "musicians"), false},
+		{filepath.Join(string(filepath.Separator), "etc"), false},
+		{string(filepath.Separator), false},
+	}
+
+	for _, c := range cases {
+		if got := isWithin(root, c.path); got != c.expected {
+			t.Errorf("isWithin(%s, %s) = %v, expected %v", root, c.path, got, c.expected)
+		}
+	}
+}
+
+func TestResolveAllowedRejectsSymlinkEscape(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "allowed_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	outside := filepath.Join(tempDir, "outside")
+	for _, dir := range []string{library, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+
+	// A symlink inside the library pointing outside of it must be refused
+	link := filepath.Join(library, "escape")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Skipf("Symlinks not supported: %v", err)
+	}
+
+	roots := resolveRoots([]string{library})
+
+	if _, err := resolveAllowed(filepath.Join(library, "Album"), roots); err != nil {
+		t.Errorf("Expected path inside library to be allowed, got %v", err)
+	}
+	if _, err := resolveAllowed(link, roots); err != errPathNotAllowed {
+		t.Errorf("Expected symlink escape to be refused, got %v", err)
+	}
+	if _, err := resolveAllowed(filepath.Join(library, "..", "outside"), roots); err != errPathNotAllowed {
+		t.Errorf("Expected parent traversal to be refused, got %v", err)
+	}
+}
+
+func TestSettingsCannotChangeRoots(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "roots_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	library := filepath.Join(tempDir, "library")
+	if err := os.Mkdir(library, 0755); err != nil {
+		t.Fatalf("Failed to create library: %v", err)
+	}
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+	}
+	server.saveSettings(AppSettings{LibraryRoots: []string{library}})
+
+	// The generic settings POST keeps the stored roots
+	req := httptest.NewRequest(http.MethodPost, "/api/settings", strings.NewReader(`{"libraryRoots": ["/"], "targetRoots": ["/etc"], "watchLibrary": true}`))
+	w := httptest.NewRecorder()
+	server.handleSettings(w, req)
+	settings := server.loadSettings()
+	if w.Code != http.StatusOK || !settings.WatchLibrary {
+		t.Fatalf("Expected the other settings saved, got %d %+v", w.Code, settings)
+	}
+	if len(settings.LibraryRoots) != 1 || settings.LibraryRoots[0] != library || settings.TargetRoots != nil {
+		t.Errorf("Expected the roots unchanged, got %+v", settings)
+	}
+
+	// The roots endpoint validates what it saves
+	for _, body := range []string{`{"libraryRoots": ["/"]}`, `{"targetRoots": ["` + filepath.Join(tempDir, "missing") + `"]}`} {
+		req = httptest.NewRequest(http.MethodPut, "/api/roots", strings.NewReader(body))
+		w = httptest.NewRecorder()
+		server.handleRoots(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, w.Code)
+		}
+	}
+	req = httptest.NewRequest(http.MethodPut, "/api/roots", strings.NewReader(`{"libraryRoots": ["`+library+`"], "targetRoots": ["`+tempDir+`"]}`))
+	w = httptest.NewRecorder()
+	server.handleRoots(w, req)
+	if w.Code != http.StatusOK || len(server.loadSettings().TargetRoots) != 1 {
+		t.Errorf("Expected the roots saved, got %d %+v", w.Code, server.loadSettings())
+	}
+}
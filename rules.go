@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	matchAll = "all"
+	matchAny = "any"
+)
+
+// SelectionRule selects albums by their scan results. Every condition that is
+// set must hold for the rule to match; Negate inverts the outcome.
+type SelectionRule struct {
+	// Artist is a case-insensitive glob ("*", "?", "[...]") when it
+	// contains one, otherwise a substring.
+	Artist          string   `json:"artist,omitempty"`
+	Genres          []string `json:"genres,omitempty"`
+	Labels          []string `json:"labels,omitempty"`
+	Formats         []string `json:"formats,omitempty"`
+	YearFrom        int      `json:"yearFrom,omitempty"`
+	YearTo          int      `json:"yearTo,omitempty"`
+	AddedWithinDays int      `json:"addedWithinDays,omitempty"`
+	MaxSizeMB       float64  `json:"maxSizeMB,omitempty"`
+	HasCover        *bool    `json:"hasCover,omitempty"`
+	Negate          bool     `json:"negate,omitempty"`
+}
+
+// RuleSet combines rules: "all" (the default) needs every rule to match,
+// "any" needs one. An empty set matches every album.
+type RuleSet struct {
+	Match string          `json:"match,omitempty"`
+	Rules []SelectionRule `json:"rules"`
+}
+
+func (rs RuleSet) validate() error {
+	if rs.Match != "" && rs.Match != matchAll && rs.Match != matchAny {
+		return fmt.Errorf("unknown match mode %q", rs.Match)
+	}
+	for _, rule := range rs.Rules {
+		if _, err := path.Match(rule.Artist, ""); err != nil {
+			return fmt.Errorf("invalid artist pattern %q: %v", rule.Artist, err)
+		}
+		if rule.YearFrom != 0 && rule.YearTo != 0 && rule.YearFrom > rule.YearTo {
+			return fmt.Errorf("year range %d-%d is empty", rule.YearFrom, rule.YearTo)
+		}
+	}
+	return nil
+}
+
+func (rs RuleSet) matches(album AlbumFolder, now time.Time) bool {
+	if len(rs.Rules) == 0 {
+		return true
+	}
+	for _, rule := range rs.Rules {
+		matched := rule.matches(album, now)
+		if rs.Match == matchAny && matched {
+			return true
+		}
+		if rs.Match != matchAny && !matched {
+			return false
+		}
+	}
+	return rs.Match != matchAny
+}
+
+func (rule SelectionRule) matches(album AlbumFolder, now time.Time) bool {
+	return rule.conditionsHold(album, now) != rule.Negate
+}
+
+func (rule SelectionRule) conditionsHold(album AlbumFolder, now time.Time) bool {
+	if rule.Artist != "" && !matchArtist(rule.Artist, album.Artist) {
+		return false
+	}
+	if len(rule.Genres) > 0 && !containsFold(rule.Genres, album.Genre) {
+		return false
+	}
+	if len(rule.Labels) > 0 && !containsFold(rule.Labels, album.Label) {
+		return false
+	}
+	if len(rule.Formats) > 0 && !containsFold(rule.Formats, album.Format) {
+		return false
+	}
+	// Albums without a year never fall inside a year range
+	if (rule.YearFrom != 0 || rule.YearTo != 0) && album.Year == 0 {
+		return false
+	}
+	if rule.YearFrom != 0 && album.Year < rule.YearFrom {
+		return false
+	}
+	if rule.YearTo != 0 && album.Year > rule.YearTo {
+		return false
+	}
+	if rule.AddedWithinDays > 0 && album.AddedAt.Before(now.AddDate(0, 0, -rule.AddedWithinDays)) {
+		return false
+	}
+	if rule.MaxSizeMB > 0 && album.SizeMB >= rule.MaxSizeMB {
+		return false
+	}
+	if rule.HasCover != nil && album.HasCover != *rule.HasCover {
+		return false
+	}
+	return true
+}
+
+func matchArtist(pattern, artist string) bool {
+	pattern, artist = strings.ToLower(pattern), strings.ToLower(artist)
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, artist)
+		return matched
+	}
+	return strings.Contains(artist, pattern)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimPrefix(v, "."), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateRules returns the albums the rule set selects, in scan order.
+func evaluateRules(albums []AlbumFolder, rules RuleSet, now time.Time) []AlbumFolder {
+	matched := []AlbumFolder{}
+	for _, album := range albums {
+		if rules.matches(album, now) {
+			matched = append(matched, album)
+		}
+	}
+	return matched
+}
+
+// handleEvaluateRules evaluates a rule set against a fresh scan of directory,
+// or against the last scan when no directory is given.
+func (s *Server) handleEvaluateRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Directory string  `json:"directory"`
+		Rules     RuleSet `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.Rules.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var albums []AlbumFolder
+	if req.Directory != "" {
+		directory, err := resolveAllowed(req.Directory, s.libraryRoots())
+		if err != nil {
+			writePathError(w, err)
+			return
+		}
+		albums = s.scanMusicFolders(directory)
+		s.storeScan(directory, albums)
+	} else {
+		s.lastScanMutex.RLock()
+		albums = s.lastScan
+		s.lastScanMutex.RUnlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(evaluateRules(albums, req.Rules, time.Now()))
+}
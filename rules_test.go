@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEvaluateRules(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	albums := []AlbumFolder{
+		{Path: "/music/a", Artist: "The Beatles", Genre: "Rock", Year: 1969, Format: "mp3", SizeMB: 80, HasCover: true, AddedAt: now.AddDate(0, 0, -3)},
+		{Path: "/music/b", Artist: "Miles Davis", Genre: "Jazz", Year: 1959, Format: "flac", SizeMB: 300, AddedAt: now.AddDate(-1, 0, 0)},
+		{Path: "/music/c", Artist: "The Necks", Genre: "jazz", Format: "mp3", SizeMB: 120, HasCover: true, AddedAt: now.AddDate(0, 0, -10)},
+	}
+
+	paths := func(rules RuleSet) []string {
+		var result []string
+		for _, album := range evaluateRules(albums, rules, now) {
+			result = append(result, album.Path)
+		}
+		return result
+	}
+	hasCover := true
+
+	tests := []struct {
+		name     string
+		rules    RuleSet
+		expected []string
+	}{
+		{"empty set matches everything", RuleSet{}, []string{"/music/a", "/music/b", "/music/c"}},
+		{"artist glob", RuleSet{Rules: []SelectionRule{{Artist: "the *"}}}, []string{"/music/a", "/music/c"}},
+		{"artist substring", RuleSet{Rules: []SelectionRule{{Artist: "davis"}}}, []string{"/music/b"}},
+		{"genre list ignores case", RuleSet{Rules: []SelectionRule{{Genres: []string{"Jazz"}}}}, []string{"/music/b", "/music/c"}},
+		{"year range skips untagged", RuleSet{Rules: []SelectionRule{{YearFrom: 1950, YearTo: 1970}}}, []string{"/music/a", "/music/b"}},
+		{"added within days", RuleSet{Rules: []SelectionRule{{AddedWithinDays: 7}}}, []string{"/music/a"}},
+		{"size and cover", RuleSet{Rules: []SelectionRule{{MaxSizeMB: 200, HasCover: &hasCover}}}, []string{"/music/a", "/music/c"}},
+		{"negate", RuleSet{Rules: []SelectionRule{{Formats: []string{"flac"}, Negate: true}}}, []string{"/music/a", "/music/c"}},
+		{"all", RuleSet{Rules: []SelectionRule{{Genres: []string{"jazz"}}, {Formats: []string{".mp3"}}}}, []string{"/music/c"}},
+		{"any", RuleSet{Match: matchAny, Rules: []SelectionRule{{Genres: []string{"rock"}}, {Formats: []string{"flac"}}}}, []string{"/music/a", "/music/b"}},
+	}
+
+	for _, test := range tests {
+		result := paths(test.rules)
+		if len(result) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, result)
+			continue
+		}
+		for i := range result {
+			if result[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, got %v", test.name, test.expected, result)
+				break
+			}
+		}
+	}
+
+	invalid := []RuleSet{
+		{Match: "some"},
+		{Rules: []SelectionRule{{Artist: "[the"}}},
+		{Rules: []SelectionRule{{YearFrom: 2000, YearTo: 1990}}},
+	}
+	for _, rules := range invalid {
+		if err := rules.validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", rules)
+		}
+	}
+}
+
+func TestScanReadsTags(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "rules_scan_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	album := filepath.Join(tempDir, "Artist", "Album")
+	createAlbum(t, album, []string{"02.flac", "03.flac"})
+	tag := id3v23(map[string]string{"TCON": "Jazz", "TYER": "1959"})
+	if err := os.WriteFile(filepath.Join(album, "01.mp3"), tag, 0644); err != nil {
+		t.Fatalf("Failed to write mp3: %v", err)
+	}
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	albums := server.scanMusicFolders(tempDir)
+	if len(albums) != 1 {
+		t.Fatalf("Expected 1 album, got %d", len(albums))
+	}
+	if albums[0].Genre != "Jazz" || albums[0].Year != 1959 || albums[0].Format != "flac" {
+		t.Errorf("Expected tag-derived fields, got %+v", albums[0])
+	}
+	if albums[0].AddedAt.IsZero() {
+		t.Error("Expected the folder's added time to be set")
+	}
+}
@@ -11,4 +11,4 @@ mkdir -p dist
 
 # Run Go backend
 echo "🚀 Starting Go backend..."
-go run main.go
\ No newline at end of file
+go run .
\ No newline at end of file
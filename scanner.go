@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scanWorkers bounds how many directories are read at once. Scans are bound
+// by filesystem latency (NAS mounts especially), not CPU.
+const scanWorkers = 16
+
+// dirListing is everything the scanner needs from one directory, read once.
+type dirListing struct {
+	path    string
+	modTime time.Time
+	files   []fileEntry
+	subdirs []string
+	err     error
+	// cached is set for listings that match the catalog; cachedAlbum is
+	// the catalogued album, reused when nothing below it changed.
+	cached      bool
+	cachedAlbum *AlbumFolder
+}
+
+type fileEntry struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// libraryTree holds the listings of a directory tree and each directory's
+// total size including everything below it.
+type libraryTree struct {
+	root     string
+	listings map[string]*dirListing
+	// order is a depth-first walk in lexical order, as filepath.WalkDir
+	// would visit the directories.
+	order []string
+	sizes map[string]int64
+}
+
+// completedDir is a directory whose whole subtree has been read.
+type completedDir struct {
+	listing        *dirListing
+	subdirs        []*dirListing
+	size           int64
+	parentHasCover bool
+}
+
+// scanStats counts a scan's progress; it is safe to read while the scan runs.
+type scanStats struct {
+	directories atomic.Int64
+	albums      atomic.Int64
+}
+
+// readListing reads one directory: its entries, and the sizes and
+// modification times of its files.
+func readListing(path string) *dirListing {
+	listing := &dirListing{path: path}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		listing.err = err
+		return listing
+	}
+	defer dir.Close()
+
+	if info, err := dir.Stat(); err == nil {
+		listing.modTime = info.ModTime()
+	}
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		listing.err = err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			listing.subdirs = append(listing.subdirs, filepath.Join(path, entry.Name()))
+			continue
+		}
+		file := fileEntry{name: entry.Name()}
+		if info, err := entry.Info(); err == nil {
+			file.size, file.modTime = info.Size(), info.ModTime()
+		}
+		listing.files = append(listing.files, file)
+	}
+
+	return listing
+}
+
+// readTree reads every directory below root with at most workers reads in
+// flight, comparing each with catalog when it is set (see
+// libraryCatalog.listing). complete, when set, is called from the workers for
+// each directory once everything below it has been read. Cancelling ctx stops the read
+// early, leaving the tree incomplete.
+func readTree(ctx context.Context, root string, workers int, catalog *libraryCatalog, stats *scanStats, complete func(completedDir)) *libraryTree {
+	tree := &libraryTree{
+		root:     root,
+		listings: make(map[string]*dirListing),
+		sizes:    make(map[string]int64),
+	}
+
+	var mutex sync.Mutex
+	cond := sync.NewCond(&mutex)
+	queue := []string{root}
+	pending := 1 // directories queued or being read
+	remaining := make(map[string]int)
+
+	// Wake idle workers so they notice the cancellation
+	stop := context.AfterFunc(ctx, func() {
+		mutex.Lock()
+		cond.Broadcast()
+		mutex.Unlock()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mutex.Lock()
+				for len(queue) == 0 && pending > 0 && ctx.Err() == nil {
+					cond.Wait()
+				}
+				if len(queue) == 0 || ctx.Err() != nil {
+					mutex.Unlock()
+					return
+				}
+				path := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				mutex.Unlock()
+
+				listing := catalog.listing(path)
+				if stats != nil {
+					stats.directories.Add(1)
+				}
+
+				mutex.Lock()
+				tree.listings[path] = listing
+				for _, file := range listing.files {
+					tree.sizes[path] += file.size
+				}
+				remaining[path] = len(listing.subdirs)
+				queue = append(queue, listing.subdirs...)
+				pending += len(listing.subdirs) - 1
+
+				// Finishing a directory may finish its parents too
+				var completed []completedDir
+				for dir := path; remaining[dir] == 0; {
+					parent := tree.listings[filepath.Dir(dir)]
+					completed = append(completed, completedDir{
+						listing:        tree.listings[dir],
+						subdirs:        tree.subdirListings(dir),
+						size:           tree.sizes[dir],
+						parentHasCover: dir != root && parent != nil && parent.hasFile("cover.jpg"),
+					})
+					if dir == root {
+						break
+					}
+					tree.sizes[parent.path] += tree.sizes[dir]
+					remaining[parent.path]--
+					dir = parent.path
+				}
+				cond.Broadcast()
+				mutex.Unlock()
+
+				if complete != nil {
+					for _, dir := range completed {
+						complete(dir)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	tree.walk(root)
+	return tree
+}
+
+// subdirListings returns the listings of path's immediate subfolders that
+// have been read.
+func (t *libraryTree) subdirListings(path string) []*dirListing {
+	var subdirs []*dirListing
+	for _, subdir := range t.listings[path].subdirs {
+		if listing, ok := t.listings[subdir]; ok {
+			subdirs = append(subdirs, listing)
+		}
+	}
+	return subdirs
+}
+
+// walk records the depth-first visiting order below path.
+func (t *libraryTree) walk(path string) {
+	listing, ok := t.listings[path]
+	if !ok {
+		return
+	}
+	t.order = append(t.order, path)
+	for _, subdir := range listing.subdirs {
+		t.walk(subdir)
+	}
+}
+
+func (l *dirListing) hasFile(name string) bool {
+	for _, file := range l.files {
+		if file.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *dirListing) fileNames() []string {
+	names := make([]string, len(l.files))
+	for i, file := range l.files {
+		names[i] = file.name
+	}
+	return names
+}
+
+// albumFileNames lists an album's files for its fingerprint: those in the
+// album folder, and those in its disc folders as "CD1/01.mp3".
+func albumFileNames(listing *dirListing, discs []*dirListing) []string {
+	names := listing.fileNames()
+	for _, disc := range discs {
+		for _, name := range disc.fileNames() {
+			names = append(names, filepath.Base(disc.path)+"/"+name)
+		}
+	}
+	return names
+}
+
+// buildAlbum describes the album in listing together with its disc
+// subfolders, using sizeBytes for everything below it and naming it with
+// naming. It reports false when the album holds no audio files.
+func (s *Server) buildAlbum(listing *dirListing, discs []*dirListing, sizeBytes int64, parentHasCover bool, naming libraryNaming) (AlbumFolder, bool) {
+	sizeMB := float64(sizeBytes) / 1024 / 1024
+	hasCover := listing.hasFile("cover.jpg") || parentHasCover
+
+	// An unchanged folder keeps its catalogued album without re-reading tags
+	unchanged := listing.cached
+	for _, disc := range discs {
+		unchanged = unchanged && disc.cached
+	}
+	if cached := listing.cachedAlbum; unchanged && cached != nil && cached.SizeMB == sizeMB && cached.HasCover == hasCover {
+		album := *cached
+		album.IsSynced = false
+		// The library patterns may have changed since
+		parsed := naming.parse(listing.path)
+		album.Artist, album.Album = parsed.Artist, parsed.Album
+		if album.Compilation {
+			album.Artist = album.AlbumArtist
+		}
+		return album, true
+	}
+
+	var totals audioTotals
+	var artists trackArtists
+	var albumTags Tags
+	discTracks := make(map[AlbumDisc]int)
+	tracks := []Track{}
+
+	// Formats are counted by content, so a mislabelled file counts as what
+	// it really is. Tracks in a disc folder belong to its disc, others to
+	// the disc their tags name. Every track's artist tags count towards
+	// telling a compilation apart.
+	addTracks := func(dir *dirListing, disc AlbumDisc) {
+		for _, file := range dir.files {
+			if !isAudioFile(file.name) {
+				continue
+			}
+			path := filepath.Join(dir.path, file.name)
+			tags, info := readTrackFile(path)
+			totals.add(info, file.size)
+			artists.add(tags)
+			albumTags.mergeAlbum(tags)
+			trackDisc := disc
+			if trackDisc.Folder == "" {
+				trackDisc.Number = tags.Disc
+			}
+			discTracks[trackDisc]++
+
+			track := newTrack(file.name, file.size, tags, info)
+			if disc.Folder != "" {
+				track.Name = disc.Folder + "/" + track.Name
+				track.DiscNumber = disc.Number
+			}
+			tracks = append(tracks, track)
+		}
+	}
+	addTracks(listing, AlbumDisc{})
+	for _, disc := range discs {
+		name := filepath.Base(disc.path)
+		number, _ := discNumber(name)
+		addTracks(disc, AlbumDisc{Number: number, Folder: name})
+	}
+
+	if totals.files == 0 {
+		return AlbumFolder{}, false
+	}
+	sortTracks(tracks)
+
+	folderName := filepath.Base(listing.path)
+	parsed := naming.parse(listing.path)
+	artist := parsed.Artist
+
+	// A compilation is by its album artist, not by a random track artist or
+	// the "Various" folder it is filed under
+	compilation := artists.compilation(artist)
+	albumArtist := artists.name(compilation)
+	if compilation {
+		artist = albumArtist
+	}
+
+	// Genre and year come from the tracks' tags, or else the path
+	genre, year := albumTags.Genre, yearFromDate(albumTags.Date)
+	if genre == "" {
+		genre = parsed.Genre
+	}
+	if year == 0 {
+		year = parsed.Year
+	}
+	trackDiscs := albumDiscs(discTracks)
+	totalDiscs := albumTags.TotalDiscs
+	if n := len(trackDiscs); n > 0 {
+		totalDiscs = max(totalDiscs, trackDiscs[n-1].Number)
+	}
+
+	return AlbumFolder{
+		Path:         listing.path,
+		Name:         folderName,
+		Artist:       artist,
+		Album:        parsed.Album,
+		Mp3Count:     totals.formats["mp3"],
+		HasCover:     hasCover,
+		SizeMB:       sizeMB,
+		IsSynced:     false,
+		Fingerprint:  s.cachedFingerprint(listing.path, albumFileNames(listing, discs)),
+		Genre:        genre,
+		Year:         year,
+		Format:       dominantFormat(totals.formats),
+		AddedAt:      listing.modTime,
+		DurationSec:  totals.durationSec,
+		BitrateKbps:  totals.bitrateKbps(),
+		Codec:        dominantFormat(totals.codecs),
+		Lossless:     totals.lossless,
+		FormatCounts: totals.formats,
+		TrackCount:   totals.files,
+		Discs:        trackDiscs,
+		Compilation:  compilation,
+		AlbumArtist:  albumArtist,
+		Date:         releaseDate(albumTags.Date),
+		Label:        albumTags.Label,
+		ReleaseID:    albumTags.ReleaseID,
+		TotalDiscs:   totalDiscs,
+		Tracks:       tracks,
+	}, true
+}
+
+// scanMusicFolders finds the albums below directory.
+func (s *Server) scanMusicFolders(directory string) []AlbumFolder {
+	albums, _ := s.scanLibrary(context.Background(), s.scanRoot(directory), directory, nil, nil)
+	return albums
+}
+
+// scanRoot is the library root albums below directory are named relative
+// to: the library root it is in, or directory itself.
+func (s *Server) scanRoot(directory string) string {
+	if root := s.libraryRootOf(directory); root != "" {
+		return root
+	}
+	return directory
+}
+
+// scanLibrary finds the albums below directory, naming them with the library
+// patterns relative to root (directory, or a library root above it when only
+// part of the library is rescanned). Each directory is read once
+// and albums are built in parallel as soon as their folder has been read
+// completely, calling found (from several goroutines) for each. The result
+// is in walk order and is recorded in the catalog. When ctx is cancelled the
+// albums found so far are returned with ctx's error.
+func (s *Server) scanLibrary(ctx context.Context, root, directory string, found func(AlbumFolder), stats *scanStats) ([]AlbumFolder, error) {
+	var mutex sync.Mutex
+	var albums []AlbumFolder
+	naming := s.libraryNaming(root)
+
+	tree := readTree(ctx, directory, scanWorkers, s.catalog, stats, func(dir completedDir) {
+		path := dir.listing.path
+		if path == directory {
+			return
+		}
+		// Disc folders are part of the album above them
+		if _, ok := discNumber(filepath.Base(path)); ok && filepath.Dir(path) != directory {
+			return
+		}
+		album, ok := s.buildAlbum(dir.listing, discListings(dir.subdirs), dir.size, dir.parentHasCover, naming)
+		if !ok {
+			return
+		}
+		if stats != nil {
+			stats.albums.Add(1)
+		}
+		mutex.Lock()
+		albums = append(albums, album)
+		mutex.Unlock()
+		if found != nil {
+			found(album)
+		}
+	})
+
+	// Workers finish in any order; return albums as a walk would find them
+	position := make(map[string]int, len(tree.order))
+	for i, path := range tree.order {
+		position[path] = i
+	}
+	sort.Slice(albums, func(i, j int) bool { return position[albums[i].Path] < position[albums[j].Path] })
+
+	if ctx.Err() != nil {
+		return albums, ctx.Err()
+	}
+	s.catalog.update(directory, tree, albums)
+	return albums, nil
+}
+
+// albumFolder describes the album in path. It reports false when path holds
+// no audio files.
+func (s *Server) albumFolder(path string) (AlbumFolder, bool) {
+	return s.albumFolderIn(s.libraryRootOf(path), path)
+}
+
+// albumFolderIn is albumFolder for an album of the library at root.
+func (s *Server) albumFolderIn(root, path string) (AlbumFolder, bool) {
+	// Files may have changed in place, so the catalog is not consulted
+	tree := readTree(context.Background(), path, scanWorkers, nil, nil, nil)
+	listing, ok := tree.listings[path]
+	if !ok || listing.err != nil {
+		return AlbumFolder{}, false
+	}
+
+	_, err := os.Stat(filepath.Join(filepath.Dir(path), "cover.jpg"))
+	naming := s.libraryNaming(root)
+	return s.buildAlbum(listing, discListings(tree.subdirListings(path)), tree.sizes[path], err == nil, naming)
+}
+
+// scanProgressInterval is how often a streaming scan reports progress.
+const scanProgressInterval = 500 * time.Millisecond
+
+// ScanProgress reports how far a streaming scan has got.
+type ScanProgress struct {
+	Directories int64 `json:"directories"`
+	Albums      int64 `json:"albums"`
+	ElapsedMs   int64 `json:"elapsed_ms"`
+}
+
+// ScanEvent is one line of a streaming scan: an album, a progress report or
+// the final "done" report.
+type ScanEvent struct {
+	Type     string        `json:"type"`
+	Album    *AlbumFolder  `json:"album,omitempty"`
+	Progress *ScanProgress `json:"progress,omitempty"`
+}
+
+// handleScanStream scans like /api/scan but streams newline-delimited JSON
+// events as albums are found. The scan stops when the client disconnects.
+func (s *Server) handleScanStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Directory string `json:"directory"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	directory, err := resolveAllowed(req.Directory, s.libraryRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	encoder := json.NewEncoder(w)
+	send := func(event ScanEvent) {
+		encoder.Encode(event)
+		flusher.Flush()
+	}
+
+	ctx := r.Context()
+	start := time.Now()
+	var stats scanStats
+	progress := func() *ScanProgress {
+		return &ScanProgress{
+			Directories: stats.directories.Load(),
+			Albums:      stats.albums.Load(),
+			ElapsedMs:   time.Since(start).Milliseconds(),
+		}
+	}
+
+	// Albums are handed over unbuffered, so all have been sent once the
+	// scan returns
+	found := make(chan AlbumFolder)
+	type scanResult struct {
+		albums []AlbumFolder
+		err    error
+	}
+	done := make(chan scanResult, 1)
+	go func() {
+		albums, err := s.scanLibrary(ctx, s.scanRoot(directory), directory, func(album AlbumFolder) {
+			select {
+			case found <- album:
+			case <-ctx.Done():
+			}
+		}, &stats)
+		done <- scanResult{albums, err}
+	}()
+
+	ticker := time.NewTicker(scanProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case album := <-found:
+			send(ScanEvent{Type: "album", Album: &album})
+		case <-ticker.C:
+			send(ScanEvent{Type: "progress", Progress: progress()})
+		case result := <-done:
+			if result.err != nil {
+				// The client is gone; keep the previous scan
+				return
+			}
+			s.storeScan(directory, result.albums)
+			send(ScanEvent{Type: "done", Progress: progress()})
+			return
+		}
+	}
+}
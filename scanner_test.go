@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanMusicFoldersSinglePass(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "scanner_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Enough albums that the workers finish out of order
+	var expected []string
+	for artist := 0; artist < 5; artist++ {
+		for album := 0; album < 8; album++ {
+			path := filepath.Join(tempDir, fmt.Sprintf("Artist %d", artist), fmt.Sprintf("Album %02d", album))
+			createAlbum(t, path, []string{"01.mp3", "02.flac"})
+			expected = append(expected, path)
+		}
+	}
+
+	// Sizes include subfolders; a cover in the artist folder counts
+	withExtras := filepath.Join(tempDir, "Artist 0", "Album 00")
+	if err := os.WriteFile(filepath.Join(withExtras, "01.mp3"), make([]byte, 1024*1024), 0644); err != nil {
+		t.Fatalf("Failed to write track: %v", err)
+	}
+	createAlbum(t, filepath.Join(withExtras, "Scans"), []string{"booklet.pdf"})
+	os.WriteFile(filepath.Join(withExtras, "Scans", "booklet.pdf"), make([]byte, 1024*1024), 0644)
+	os.WriteFile(filepath.Join(tempDir, "Artist 1", "cover.jpg"), []byte("jpg"), 0644)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	albums := server.scanMusicFolders(tempDir)
+	if len(albums) != len(expected) {
+		t.Fatalf("Expected %d albums, got %d", len(expected), len(albums))
+	}
+	for i, album := range albums {
+		if album.Path != expected[i] {
+			t.Fatalf("Expected %s at position %d, got %s", expected[i], i, album.Path)
+		}
+	}
+
+	if albums[0].SizeMB != 2 {
+		t.Errorf("Expected 2 MB including the subfolder, got %v", albums[0].SizeMB)
+	}
+	if albums[0].HasCover || !albums[8].HasCover {
+		t.Errorf("Expected only Artist 1's albums to have the shared cover")
+	}
+	// One mp3 and one flac: ties go to the first format by name
+	if albums[0].Mp3Count != 1 || albums[0].Format != "flac" {
+		t.Errorf("Unexpected counts: %+v", albums[0])
+	}
+
+	// Fingerprints match the ones computed folder by folder
+	fresh := &Server{fingerprintCache: make(map[string]string)}
+	for _, album := range albums {
+		if fingerprint := fresh.generateFolderFingerprint(album.Path); fingerprint != album.Fingerprint {
+			t.Errorf("Fingerprint mismatch for %s", album.Path)
+		}
+	}
+
+	// Repeated scans give the same result
+	again := server.scanMusicFolders(tempDir)
+	for i := range albums {
+		if again[i].Path != albums[i].Path || again[i].Fingerprint != albums[i].Fingerprint {
+			t.Fatalf("Expected identical rescans, differ at %d", i)
+		}
+	}
+}
+
+func TestScanStream(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "scan_stream_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for i := 0; i < 5; i++ {
+		createAlbum(t, filepath.Join(tempDir, "Artist", fmt.Sprintf("Album %d", i)), []string{"01.mp3"})
+	}
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+	}
+	server.updateSettings(func(settings *AppSettings) error {
+		settings.LibraryRoots = []string{tempDir}
+		return nil
+	})
+
+	body := strings.NewReader(fmt.Sprintf(`{"directory": %q}`, tempDir))
+	recorder := httptest.NewRecorder()
+	server.handleScanStream(recorder, httptest.NewRequest(http.MethodPost, "/api/scan/stream", body))
+
+	var events []ScanEvent
+	decoder := json.NewDecoder(recorder.Body)
+	for decoder.More() {
+		var event ScanEvent
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatalf("Invalid NDJSON line: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	albums := 0
+	for _, event := range events {
+		if event.Type == "album" {
+			albums++
+		}
+	}
+	last := events[len(events)-1]
+	if albums != 5 || last.Type != "done" || last.Progress.Albums != 5 || last.Progress.Directories != 7 {
+		t.Errorf("Expected 5 albums then done, got %d albums and %+v", albums, last)
+	}
+	if len(server.lastScan) != 5 {
+		t.Errorf("Expected the finished scan to be kept, got %d albums", len(server.lastScan))
+	}
+
+	// A cancelled scan stops and reports why
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := server.scanLibrary(ctx, tempDir, tempDir, nil, nil); err != context.Canceled {
+		t.Errorf("Expected the scan to be cancelled, got %v", err)
+	}
+}
+
+func TestScanCountsFormatsByContent(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "scanner_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A FLAC album with one real MP3 and one FLAC file named .mp3
+	albumPath := filepath.Join(tempDir, "Artist", "Album")
+	createAlbum(t, albumPath, []string{"notes.txt"})
+	files := map[string][]byte{
+		"01.flac": flacStreamInfo(44100, 441000),
+		"02.flac": flacStreamInfo(44100, 441000),
+		"03.mp3":  flacStreamInfo(44100, 441000),
+		"04.mp3":  mp3Frames(10, nil),
+		"05.opus": oggPage(0, append([]byte("OpusHead\x01\x02"), make([]byte, 11)...)),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(albumPath, name), data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+	albums := server.scanMusicFolders(tempDir)
+	if len(albums) != 1 {
+		t.Fatalf("Expected 1 album, got %d", len(albums))
+	}
+
+	album := albums[0]
+	if album.TrackCount != 5 || album.Mp3Count != 1 || album.Format != "flac" {
+		t.Errorf("Expected 5 tracks, 1 MP3 and FLAC overall, got %+v", album)
+	}
+	if album.FormatCounts["flac"] != 3 || album.FormatCounts["mp3"] != 1 || album.FormatCounts["opus"] != 1 {
+		t.Errorf("Unexpected format counts %v", album.FormatCounts)
+	}
+}
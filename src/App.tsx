@@ -286,7 +286,7 @@ function App() {
       try {
         if (album.is_synced) {
           // Remove from sync (unsync)
-          const response = await fetch('/api/unsync', {
+          const unsync = (adopt: boolean) => fetch('/api/unsync', {
             method: 'POST',
             headers: {
               'Content-Type': 'application/json',
@@ -294,9 +294,20 @@ function App() {
             body: JSON.stringify({
               targetDirectory,
               albumName: album.name,
+              sourcePath: album.path,
+              adopt,
             }),
           });
           
+          let response = await unsync(false);
+          // A copy made before music-sync kept a manifest is only removed
+          // when it matches the album exactly and the user agrees
+          if (response.status === 409 && window.confirm(
+            `${album.artist} - ${album.album} was not copied by music-sync.\n\nRemove it from the target anyway if it is an exact copy of this album?`
+          )) {
+            response = await unsync(true);
+          }
+          
           if (response.ok) {
             unsyncedCount++;
             results.push(`🗑️ Removed: ${album.artist} - ${album.album}`);
@@ -343,6 +354,32 @@ function App() {
     showNotification("Sync Complete", summary, "success");
   };
 
+  const emptyTrash = async () => {
+    if (!window.confirm(`Permanently delete the albums removed from ${targetDirectory}? This cannot be undone.`)) {
+      return;
+    }
+    
+    try {
+      const response = await fetch('/api/trash/purge', {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ targetDirectory }),
+      });
+      
+      if (response.ok) {
+        const { purged } = await response.json();
+        showNotification("Trash Emptied", `${purged} removed albums deleted from the target`, "success");
+      } else {
+        showNotification("Error", `Failed to empty trash: ${await response.text()}`, "error");
+      }
+    } catch (error) {
+      console.error('Error emptying trash:', error);
+      showNotification("Error", `Failed to empty trash: ${error}`, "error");
+    }
+  };
+
   return (
     <div className="app">
       <header className="app-header">
@@ -361,6 +398,11 @@ function App() {
               {targetDirectory || "Choose Sync Target"}
             </button>
             {targetDirectory && <div className="selected-path">{targetDirectory}</div>}
+            {targetDirectory && (
+              <button onClick={emptyTrash} className="trash-button">
+                Empty Trash
+              </button>
+            )}
           </div>
         </div>
         {selectedAlbums.size > 0 && (
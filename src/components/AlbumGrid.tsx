@@ -59,7 +59,7 @@ const AlbumCard: React.FC<AlbumCardProps> = ({ album, isSelected, onToggle }) =>
         <div className="artist-name">{album.artist}</div>
         <div className="album-name">{album.album}</div>
         <div className="album-stats">
-          {album.mp3_count} tracks • {album.size_mb.toFixed(1)} MB
+          {album.track_count} tracks • {album.size_mb.toFixed(1)} MB
         </div>
       </div>
     </div>
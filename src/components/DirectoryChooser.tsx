@@ -1,4 +1,5 @@
 import React, { useState, useEffect } from "react";
+import { DriveInfo } from "../types";
 
 interface DirectoryChooserProps {
   isOpen: boolean;
@@ -20,7 +21,7 @@ const DirectoryChooser: React.FC<DirectoryChooserProps> = ({
   title,
 }) => {
   const [currentPath, setCurrentPath] = useState("");
-  const [drives, setDrives] = useState<string[]>([]);
+  const [drives, setDrives] = useState<DriveInfo[]>([]);
   const [directories, setDirectories] = useState<DirectoryItem[]>([]);
   const [loading, setLoading] = useState(false);
 
@@ -68,8 +69,13 @@ const DirectoryChooser: React.FC<DirectoryChooserProps> = ({
     }
   };
 
-  const handleDriveClick = (drive: string) => {
-    loadDirectory(drive);
+  const handleDriveClick = (drive: DriveInfo) => {
+    loadDirectory(drive.path);
+  };
+
+  const formatSize = (bytes: number) => {
+    const gb = bytes / (1024 * 1024 * 1024);
+    return gb >= 1 ? `${gb.toFixed(1)} GB` : `${(bytes / (1024 * 1024)).toFixed(0)} MB`;
   };
 
   const handleDirectoryClick = (item: DirectoryItem) => {
@@ -113,11 +119,18 @@ const DirectoryChooser: React.FC<DirectoryChooserProps> = ({
               <div className="drives-list">
                 {drives.map((drive) => (
                   <button
-                    key={drive}
+                    key={drive.path}
                     className="drive-item"
                     onClick={() => handleDriveClick(drive)}
+                    title={drive.device ? `${drive.device} (${drive.fsType})` : undefined}
                   >
-                    📁 {drive}
+                    {drive.removable ? '💾' : '📁'} {drive.label || drive.path}
+                    {drive.label && <span className="drive-path"> {drive.path}</span>}
+                    {drive.totalBytes > 0 && (
+                      <span className="drive-space">
+                        {' '}· {formatSize(drive.freeBytes)} free of {formatSize(drive.totalBytes)}
+                      </span>
+                    )}
                   </button>
                 ))}
               </div>
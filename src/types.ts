@@ -8,9 +8,194 @@ export interface AlbumFolder {
   size_mb: number;
   is_synced: boolean;
   fingerprint: string;
+  genre?: string;
+  year?: number;
+  format: string;
+  added_at: string;
+  duration_sec: number;
+  bitrate_kbps?: number;
+  codec?: string;
+  lossless: boolean;
+  format_counts: Record<string, number>;
+  track_count: number;
+  discs?: AlbumDisc[];
+  compilation: boolean;
+  album_artist?: string;
+  date?: string;
+  label?: string;
+  musicbrainz_release_id?: string;
+  total_discs?: number;
+}
+
+export interface AlbumDisc {
+  number: number;
+  folder?: string;
+  track_count: number;
 }
 
 export interface AppSettings {
   lastSourceDirectory: string;
   lastTargetDirectory: string;
-}
\ No newline at end of file
+  libraryRoots?: string[];
+  targetRoots?: string[];
+  conflictPolicy?: "rename" | "skip" | "overwrite";
+  syncSets?: SyncSet[];
+  devices?: DeviceProfile[];
+  activeDevice?: string;
+  watchLibrary?: boolean;
+  libraryPatterns?: string[];
+}
+
+export interface ParsedPath {
+  path: string;
+  relative: string;
+  pattern?: string;
+  artist: string;
+  album: string;
+  year?: number;
+  genre?: string;
+}
+
+export interface AlbumRef {
+  path?: string;
+  fingerprint?: string;
+}
+
+export interface SyncSet {
+  name: string;
+  albums: AlbumRef[];
+  rules?: RuleSet;
+  targetDirectory?: string;
+  mirror?: boolean;
+  conflictPolicy?: "rename" | "skip" | "overwrite";
+  updatedAt?: string;
+}
+export interface SelectionRule {
+  artist?: string;
+  genres?: string[];
+  labels?: string[];
+  formats?: string[];
+  yearFrom?: number;
+  yearTo?: number;
+  addedWithinDays?: number;
+  maxSizeMB?: number;
+  hasCover?: boolean;
+  negate?: boolean;
+}
+
+export interface RuleSet {
+  match?: "all" | "any";
+  rules: SelectionRule[];
+}
+
+export interface DeviceProfile {
+  name: string;
+  mountPath?: string;
+  volumeLabel?: string;
+  volumeUUID?: string;
+  layoutTemplate?: string;
+  compilationTemplate?: string;
+  filenameRules: {
+    fatSafe?: boolean;
+    asciiOnly?: boolean;
+    maxLength?: number;
+    replacement?: string;
+  };
+  transcode: {
+    format?: "mp3" | "aac" | "ogg" | "opus" | "flac";
+    bitrateKbps?: number;
+  };
+  capacityReserveMB?: number;
+  defaultSyncSet?: string;
+  autoSync?: boolean;
+  autoSyncDryRun?: boolean;
+  updatedAt?: string;
+}
+
+export interface LayoutPreview {
+  path: string;
+  folder: string;
+  compilation: boolean;
+}
+
+export interface DevicePresence {
+  name: string;
+  present: boolean;
+  mountPath?: string;
+  matchedBy?: "uuid" | "label" | "marker" | "path";
+}
+
+export interface DriveInfo {
+  path: string;
+  mountPath?: string;
+  device?: string;
+  fsType?: string;
+  label?: string;
+  totalBytes: number;
+  freeBytes: number;
+  removable: boolean;
+}
+
+export interface SyncSetRun {
+  missing: AlbumRef[];
+  mirror?: unknown;
+  results?: unknown[];
+  errors?: string[];
+  held?: string;
+}
+
+export interface AutoSyncRun {
+  device: string;
+  syncSet: string;
+  mountPath: string;
+  dryRun: boolean;
+  startedAt: string;
+  finishedAt: string;
+  run?: SyncSetRun;
+  error?: string;
+}
+
+export interface ServerEvent {
+  type: string;
+  time: string;
+  data?: unknown;
+}
+
+export interface ScanProgress {
+  directories: number;
+  albums: number;
+  elapsed_ms: number;
+}
+
+export interface ScanEvent {
+  type: "album" | "progress" | "done";
+  album?: AlbumFolder;
+  progress?: ScanProgress;
+}
+
+export interface AlbumPage {
+  albums: AlbumFolder[];
+  total: number;
+  page: number;
+  limit: number;
+}
+
+export interface Track {
+  name: string;
+  track_number?: number;
+  disc_number?: number;
+  title: string;
+  artist?: string;
+  duration_sec: number;
+  bitrate_kbps?: number;
+  sample_rate?: number;
+  codec: string;
+  size_bytes: number;
+}
+
+export interface SelectionSummary {
+  albums: number;
+  size_mb: number;
+  duration_sec: number;
+  missing: AlbumRef[];
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AlbumRef identifies an album in a saved selection. The path is tried first;
+// the fingerprint finds the album again after the library was moved.
+type AlbumRef struct {
+	Path        string `json:"path,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// SyncSet is a named, saved selection of albums together with where and how
+// to sync it. Rules add every album of the last scan they select.
+type SyncSet struct {
+	Name            string     `json:"name"`
+	Albums          []AlbumRef `json:"albums"`
+	Rules           *RuleSet   `json:"rules,omitempty"`
+	TargetDirectory string     `json:"targetDirectory,omitempty"`
+	Mirror          bool       `json:"mirror,omitempty"`
+	ConflictPolicy  string     `json:"conflictPolicy,omitempty"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+type SyncSetRun struct {
+	Missing []AlbumRef    `json:"missing"`
+	Mirror  *MirrorResult `json:"mirror,omitempty"`
+	Results []SyncResult  `json:"results,omitempty"`
+	Errors  []string      `json:"errors,omitempty"`
+	// Held says why a mirror was only planned instead of run.
+	Held string `json:"held,omitempty"`
+}
+
+var errSyncSetNotFound = errors.New("sync set not found")
+
+func validSyncSetName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("sync set name required")
+	}
+	if strings.ContainsAny(name, "/\\") {
+		return errors.New("sync set name cannot contain slashes")
+	}
+	return nil
+}
+
+func findSyncSet(sets []SyncSet, name string) int {
+	for i, set := range sets {
+		if set.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) syncSet(name string) (SyncSet, error) {
+	sets := s.loadSettings().SyncSets
+	if i := findSyncSet(sets, name); i != -1 {
+		return sets[i], nil
+	}
+	return SyncSet{}, errSyncSetNotFound
+}
+
+// saveSyncSet stores set, creating it or replacing the one with the same name.
+// With create set, an existing set of that name is an error.
+func (s *Server) saveSyncSet(set SyncSet, create bool) error {
+	if err := validSyncSetName(set.Name); err != nil {
+		return err
+	}
+	if set.ConflictPolicy != "" && !validConflictPolicy(set.ConflictPolicy) {
+		return fmt.Errorf("unknown conflict policy %q", set.ConflictPolicy)
+	}
+	if set.Rules != nil {
+		if err := set.Rules.validate(); err != nil {
+			return err
+		}
+	}
+	_, _, set.Albums = s.resolveAlbumRefs(set.Albums)
+	set.UpdatedAt = time.Now()
+
+	return s.updateSettings(func(settings *AppSettings) error {
+		i := findSyncSet(settings.SyncSets, set.Name)
+		if i == -1 {
+			settings.SyncSets = append(settings.SyncSets, set)
+			return nil
+		}
+		if create {
+			return fmt.Errorf("sync set %q already exists", set.Name)
+		}
+		settings.SyncSets[i] = set
+		return nil
+	})
+}
+
+func (s *Server) deleteSyncSet(name string) error {
+	return s.updateSettings(func(settings *AppSettings) error {
+		i := findSyncSet(settings.SyncSets, name)
+		if i == -1 {
+			return errSyncSetNotFound
+		}
+		settings.SyncSets = append(settings.SyncSets[:i], settings.SyncSets[i+1:]...)
+		for j := range settings.Devices {
+			if settings.Devices[j].DefaultSyncSet == name {
+				settings.Devices[j].DefaultSyncSet = ""
+				settings.Devices[j].AutoSync = false
+			}
+		}
+		return nil
+	})
+}
+
+// resolveAlbumRefs turns a saved selection into source paths. An album is
+// used from its saved path whenever that still exists, even if its files
+// changed; only albums whose path is gone are looked up by fingerprint in the
+// last scan, and the rest are returned as missing. refreshed is refs with the
+// paths and fingerprints found, in the same order, for saving back.
+func (s *Server) resolveAlbumRefs(refs []AlbumRef) (paths []string, missing, refreshed []AlbumRef) {
+	s.lastScanMutex.RLock()
+	byFingerprint := make(map[string]string, len(s.lastScan))
+	for _, album := range s.lastScan {
+		byFingerprint[album.Fingerprint] = album.Path
+	}
+	s.lastScanMutex.RUnlock()
+
+	missing = []AlbumRef{}
+	refreshed = make([]AlbumRef, 0, len(refs))
+	for _, ref := range refs {
+		if ref.Path != "" {
+			if info, err := os.Stat(ref.Path); err == nil && info.IsDir() {
+				paths = append(paths, ref.Path)
+				ref.Fingerprint = s.generateFolderFingerprint(ref.Path)
+				refreshed = append(refreshed, ref)
+				continue
+			}
+		}
+		if path, ok := byFingerprint[ref.Fingerprint]; ok && ref.Fingerprint != "" {
+			paths = append(paths, path)
+			ref.Path = path
+		} else {
+			missing = append(missing, ref)
+		}
+		refreshed = append(refreshed, ref)
+	}
+
+	return paths, missing, refreshed
+}
+
+// ruleSelection returns the paths of the last scan's albums that rules selects
+// and that are not already in paths.
+func (s *Server) ruleSelection(rules RuleSet, paths []string) []string {
+	s.lastScanMutex.RLock()
+	matched := evaluateRules(s.lastScan, rules, time.Now())
+	s.lastScanMutex.RUnlock()
+
+	var selected []string
+	for _, album := range matched {
+		if !containsString(paths, album.Path) && !containsString(selected, album.Path) {
+			selected = append(selected, album.Path)
+		}
+	}
+	return selected
+}
+
+// runSyncSet syncs the set's albums to targetDirectory. Mirror sets also
+// remove other music-sync albums from the target.
+func (s *Server) runSyncSet(sourcePaths []string, targetDirectory string, mirror bool, options SyncOptions) (SyncSetRun, error) {
+	var run SyncSetRun
+
+	if mirror {
+		result, err := s.mirror(sourcePaths, targetDirectory, options)
+		if err != nil {
+			return run, err
+		}
+		run.Mirror = &result
+		return run, nil
+	}
+
+	for _, sourcePath := range sourcePaths {
+		result, err := s.syncAlbum(sourcePath, targetDirectory, options)
+		if err != nil {
+			run.Errors = append(run.Errors, fmt.Sprintf("Error syncing %s: %v", sourcePath, err))
+			continue
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	return run, nil
+}
+
+// refreshSyncSet saves the paths and fingerprints albums were found under
+// when set was run, unless the set has been changed since it was read.
+func (s *Server) refreshSyncSet(set SyncSet, refreshed []AlbumRef) {
+	if slices.Equal(set.Albums, refreshed) {
+		return
+	}
+	err := s.updateSettings(func(settings *AppSettings) error {
+		i := findSyncSet(settings.SyncSets, set.Name)
+		if i == -1 || !slices.Equal(settings.SyncSets[i].Albums, set.Albums) {
+			return nil
+		}
+		settings.SyncSets[i].Albums = refreshed
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to refresh sync set %s: %v", set.Name, err)
+	}
+}
+
+// runSavedSyncSet resolves a saved set's albums and target and syncs them.
+// Options without a conflict policy use the set's. Mirror sets with albums
+// that cannot be found, or none at all, are only planned.
+func (s *Server) runSavedSyncSet(set SyncSet, target string, options SyncOptions) (SyncSetRun, error) {
+	targetDirectory, err := resolveAllowed(target, s.targetRoots())
+	if err != nil {
+		return SyncSetRun{}, err
+	}
+
+	if options.ConflictPolicy == "" {
+		options.ConflictPolicy = set.ConflictPolicy
+	}
+	options, err = s.syncOptions(options, targetDirectory)
+	if err != nil {
+		return SyncSetRun{}, err
+	}
+
+	sourcePaths, missing, refreshed := s.resolveAlbumRefs(set.Albums)
+	s.refreshSyncSet(set, refreshed)
+	if set.Rules != nil {
+		sourcePaths = append(sourcePaths, s.ruleSelection(*set.Rules, sourcePaths)...)
+	}
+	libraryRoots := s.libraryRoots()
+	for i, sourcePath := range sourcePaths {
+		sourcePaths[i], err = resolveAllowed(sourcePath, libraryRoots)
+		if err != nil {
+			return SyncSetRun{}, err
+		}
+	}
+
+	// A mirror removes whatever it is not given, so an offline library or
+	// albums that cannot be found would empty the target
+	held := ""
+	if set.Mirror && !options.DryRun {
+		switch {
+		case len(sourcePaths) == 0:
+			held = "the set selects no albums"
+		case len(missing) > 0:
+			held = fmt.Sprintf("%d of the set's albums could not be found", len(missing))
+		}
+		options.DryRun = held != ""
+	}
+
+	run, err := s.runSyncSet(sourcePaths, targetDirectory, set.Mirror, options)
+	run.Missing, run.Held = missing, held
+	return run, err
+}
+
+func (s *Server) handleSyncSets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sets := s.loadSettings().SyncSets
+		if sets == nil {
+			sets = []SyncSet{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sets)
+	case http.MethodPost:
+		var set SyncSet
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.saveSyncSet(set, true); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "created"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSyncSet serves /api/sync-sets/{name} and /api/sync-sets/{name}/run.
+func (s *Server) handleSyncSet(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/sync-sets/")
+	if runName, ok := strings.CutSuffix(name, "/run"); ok {
+		s.handleRunSyncSet(w, r, runName)
+		return
+	}
+	if name == "" {
+		http.Error(w, "Sync set name required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		set, err := s.syncSet(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(set)
+	case http.MethodPut:
+		var set SyncSet
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		set.Name = name
+		if err := s.saveSyncSet(set, false); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "saved"})
+	case http.MethodDelete:
+		if err := s.deleteSyncSet(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleRunSyncSet(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		TargetDirectory string `json:"targetDirectory"`
+		SyncOptions
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	set, err := s.syncSet(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// The request may point the set at a different target
+	target := set.TargetDirectory
+	if req.TargetDirectory != "" {
+		target = req.TargetDirectory
+	}
+
+	run, err := s.runSavedSyncSet(set, target, req.SyncOptions)
+	if errors.Is(err, errInsufficientSpace) {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
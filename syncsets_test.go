@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncSetCRUD(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "syncset_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+	}
+
+	car := SyncSet{Name: "Car", Albums: []AlbumRef{{Path: "/music/a"}}, TargetDirectory: "/media/car"}
+	if err := server.saveSyncSet(car, true); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := server.saveSyncSet(car, true); err == nil {
+		t.Error("Expected creating a duplicate set to fail")
+	}
+	if err := server.saveSyncSet(SyncSet{Name: "a/b"}, true); err == nil {
+		t.Error("Expected a name with a slash to be refused")
+	}
+
+	car.Albums = append(car.Albums, AlbumRef{Path: "/music/b"})
+	if err := server.saveSyncSet(car, false); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// Other settings survive edits to sync sets
+	if err := server.updateSettings(func(settings *AppSettings) error {
+		settings.LastSourceDirectory = "/music"
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+
+	stored, err := server.syncSet("Car")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(stored.Albums) != 2 || stored.TargetDirectory != "/media/car" {
+		t.Errorf("Expected updated set, got %+v", stored)
+	}
+	if server.loadSettings().LastSourceDirectory != "/music" {
+		t.Error("Expected other settings to be kept")
+	}
+
+	if err := server.deleteSyncSet("Car"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := server.syncSet("Car"); err != errSyncSetNotFound {
+		t.Errorf("Expected deleted set to be gone, got %v", err)
+	}
+}
+
+func TestResolveAlbumRefsAfterMove(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "syncset_resolve_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	oldLibrary := filepath.Join(tempDir, "old")
+	newLibrary := filepath.Join(tempDir, "new")
+	stays := filepath.Join(newLibrary, "Artist", "Stays")
+	createAlbum(t, filepath.Join(oldLibrary, "Artist", "Moved"), []string{"01.mp3"})
+	createAlbum(t, stays, []string{"01.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	movedRef := AlbumRef{
+		Path:        filepath.Join(oldLibrary, "Artist", "Moved"),
+		Fingerprint: server.generateFolderFingerprint(filepath.Join(oldLibrary, "Artist", "Moved")),
+	}
+
+	// The library moves and is rescanned
+	if err := os.Rename(filepath.Join(oldLibrary, "Artist", "Moved"), filepath.Join(newLibrary, "Artist", "Moved")); err != nil {
+		t.Fatalf("Failed to move album: %v", err)
+	}
+	server.lastScan = server.scanMusicFolders(newLibrary)
+
+	refs := []AlbumRef{
+		movedRef,
+		{Path: stays},
+		{Path: filepath.Join(tempDir, "gone"), Fingerprint: "unknown"},
+	}
+	paths, missing, _ := server.resolveAlbumRefs(refs)
+
+	expected := []string{filepath.Join(newLibrary, "Artist", "Moved"), stays}
+	if len(paths) != 2 || paths[0] != expected[0] || paths[1] != expected[1] {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+	if len(missing) != 1 || missing[0].Fingerprint != "unknown" {
+		t.Errorf("Expected one missing album, got %+v", missing)
+	}
+}
+
+func TestSyncSetKeepsChangedAlbums(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "syncset_changed_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	album := filepath.Join(tempDir, "library", "Artist", "Album")
+	target := filepath.Join(tempDir, "target")
+	createAlbum(t, album, []string{"01.mp3"})
+	createAlbum(t, target, nil)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+	}
+	if err := server.updateSettings(func(settings *AppSettings) error {
+		settings.LibraryRoots = []string{tempDir}
+		settings.TargetRoots = []string{tempDir}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+
+	// Saving fills in the fingerprint
+	if err := server.saveSyncSet(SyncSet{Name: "Car", Albums: []AlbumRef{{Path: album}}}, true); err != nil {
+		t.Fatalf("Failed to create sync set: %v", err)
+	}
+	set, _ := server.syncSet("Car")
+	saved := set.Albums[0].Fingerprint
+	if saved == "" {
+		t.Fatalf("Expected the fingerprint to be saved, got %+v", set.Albums)
+	}
+
+	// A track is added: the album is still where it was, so it is synced
+	createAlbum(t, album, []string{"02.mp3"})
+	server.invalidateFingerprint(album)
+	run, err := server.runSavedSyncSet(set, target, SyncOptions{})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(run.Missing) != 0 || len(run.Results) != 1 {
+		t.Errorf("Expected the changed album to be synced, got %+v", run)
+	}
+	if _, err := os.Stat(filepath.Join(target, "Album", "02.mp3")); err != nil {
+		t.Errorf("Expected the new track on the target: %v", err)
+	}
+
+	set, _ = server.syncSet("Car")
+	if fingerprint := set.Albums[0].Fingerprint; fingerprint == saved || fingerprint != server.generateFolderFingerprint(album) {
+		t.Errorf("Expected the stored fingerprint to be refreshed, got %q", fingerprint)
+	}
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Tags holds the metadata music-sync reads from an audio file.
+type Tags struct {
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Genre       string
+	Date        string
+	Label       string
+	ReleaseID   string // MusicBrainz release (album) ID
+	Track       int
+	Disc        int
+	TotalDiscs  int
+	Compilation bool
+}
+
+var errNoTags = errors.New("no supported tags found")
+
+// maxTagSize bounds how much of a file's tags is read, whatever its headers
+// claim. Embedded cover art makes tags large, but not this large.
+const maxTagSize = 16 * 1024 * 1024
+
+// readTags reads ID3v2/ID3v1 tags from MP3 files, Vorbis comments from FLAC,
+// Ogg Vorbis and Opus files, and iTunes-style ilst atoms from MP4 files.
+func readTags(path string) (Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+	return readTagsFrom(f)
+}
+
+// readTagsFrom is readTags for a file that is already open, read from its
+// start.
+func readTagsFrom(f *os.File) (Tags, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Tags{}, err
+	}
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return Tags{}, errNoTags
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("ID3")):
+		tags, err := readID3v2(f, header)
+		if err == nil {
+			return tags, nil
+		}
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return readFLACTags(f)
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return readOggTags(f)
+	case string(header[4:8]) == "ftyp":
+		info, err := f.Stat()
+		if err != nil {
+			return Tags{}, err
+		}
+		return readMP4Tags(f, info.Size())
+	}
+
+	return readID3v1(f)
+}
+
+// syncsafe decodes the 7-bits-per-byte integers ID3v2 uses for sizes.
+func syncsafe(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<7 | int(c&0x7f)
+	}
+	return n
+}
+
+func readID3v2(f *os.File, header []byte) (Tags, error) {
+	var tags Tags
+
+	version := header[3]
+	flags := header[5]
+	size := syncsafe(header[6:10])
+
+	// A corrupt header can claim up to 256 MB; frames past what is read
+	// (usually cover art) are ignored
+	info, err := f.Stat()
+	if err != nil {
+		return tags, err
+	}
+	size = int(min(int64(size), info.Size()-10, maxTagSize))
+	if size <= 0 {
+		return tags, errNoTags
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return tags, err
+	}
+
+	// Skip the extended header
+	if flags&0x40 != 0 && version >= 3 && len(data) >= 4 {
+		extSize := int(binary.BigEndian.Uint32(data[:4])) + 4
+		if version == 4 {
+			extSize = syncsafe(data[:4])
+		}
+		if extSize > len(data) {
+			return tags, errNoTags
+		}
+		data = data[extSize:]
+	}
+
+	found := false
+	for len(data) > 0 {
+		var id string
+		var frameSize, headerSize int
+
+		if version == 2 {
+			if len(data) < 6 {
+				break
+			}
+			id = string(data[:3])
+			frameSize = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+			headerSize = 6
+		} else {
+			if len(data) < 10 {
+				break
+			}
+			id = string(data[:4])
+			if version == 4 {
+				frameSize = syncsafe(data[4:8])
+			} else {
+				frameSize = int(binary.BigEndian.Uint32(data[4:8]))
+			}
+			headerSize = 10
+		}
+
+		// Padding or a corrupt frame ends the tag
+		if id[0] == 0 || frameSize <= 0 || headerSize+frameSize > len(data) {
+			break
+		}
+
+		frame := data[headerSize : headerSize+frameSize]
+		data = data[headerSize+frameSize:]
+
+		if id[0] != 'T' {
+			continue
+		}
+		if id == "TXXX" || id == "TXX" {
+			if values := decodeID3Values(frame); len(values) == 2 && strings.EqualFold(values[0], "MusicBrainz Album Id") {
+				tags.ReleaseID = values[1]
+				found = true
+			}
+			continue
+		}
+		value := decodeID3Text(frame)
+
+		switch id {
+		case "TIT2", "TT2":
+			tags.Title = value
+		case "TPE1", "TP1":
+			tags.Artist = value
+		case "TALB", "TAL":
+			tags.Album = value
+		case "TPE2", "TP2":
+			tags.AlbumArtist = value
+		case "TCON", "TCO":
+			tags.Genre = normalizeGenre(value)
+		case "TDRC", "TYER", "TYE":
+			tags.Date = value
+		case "TPUB", "TPB":
+			tags.Label = value
+		case "TRCK", "TRK":
+			tags.Track = leadingNumber(value)
+		case "TPOS", "TPA":
+			tags.Disc, tags.TotalDiscs = numberOfTotal(value)
+		case "TCMP", "TCP":
+			tags.Compilation = value == "1"
+		default:
+			continue
+		}
+		found = true
+	}
+
+	if !found {
+		return tags, errNoTags
+	}
+	return tags, nil
+}
+
+// decodeID3Text decodes a text frame body: an encoding byte followed by the
+// text. ID3v2.4 separates multiple values with NULs; only the first is kept.
+func decodeID3Text(frame []byte) string {
+	values := decodeID3Values(frame)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// decodeID3Values decodes every NUL-separated value of a text frame body,
+// such as the description and value of a TXXX frame.
+func decodeID3Values(frame []byte) []string {
+	if len(frame) < 2 {
+		return nil
+	}
+
+	encoding, text := frame[0], frame[1:]
+	var values []string
+
+	switch encoding {
+	case 1, 2: // UTF-16 with a BOM per value, UTF-16BE
+		bigEndian := encoding == 2
+		var units []uint16
+		for i := 0; i+1 < len(text); i += 2 {
+			switch {
+			case text[i] == 0xff && text[i+1] == 0xfe && len(units) == 0:
+				bigEndian = false
+			case text[i] == 0xfe && text[i+1] == 0xff && len(units) == 0:
+				bigEndian = true
+			case text[i] == 0 && text[i+1] == 0:
+				values = append(values, string(utf16.Decode(units)))
+				units = nil
+			case bigEndian:
+				units = append(units, binary.BigEndian.Uint16(text[i:]))
+			default:
+				units = append(units, binary.LittleEndian.Uint16(text[i:]))
+			}
+		}
+		values = append(values, string(utf16.Decode(units)))
+	case 3: // UTF-8
+		values = strings.Split(string(text), "\x00")
+	default: // ISO-8859-1
+		for _, latin1 := range bytes.Split(text, []byte{0}) {
+			runes := make([]rune, len(latin1))
+			for i, c := range latin1 {
+				runes[i] = rune(c)
+			}
+			values = append(values, string(runes))
+		}
+	}
+
+	// A trailing terminator does not start another value
+	if len(values) > 1 && values[len(values)-1] == "" {
+		values = values[:len(values)-1]
+	}
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+// readID3v1 reads the fixed 128-byte tag at the end of older MP3 files.
+func readID3v1(f *os.File) (Tags, error) {
+	var tags Tags
+
+	if _, err := f.Seek(-128, io.SeekEnd); err != nil {
+		return tags, errNoTags
+	}
+	data := make([]byte, 128)
+	if _, err := io.ReadFull(f, data); err != nil || !bytes.HasPrefix(data, []byte("TAG")) {
+		return tags, errNoTags
+	}
+
+	field := func(b []byte) string {
+		b, _, _ = bytes.Cut(b, []byte{0})
+		return strings.TrimSpace(string(b))
+	}
+
+	tags.Title = field(data[3:33])
+	tags.Artist = field(data[33:63])
+	tags.Album = field(data[63:93])
+	tags.Date = field(data[93:97])
+	// ID3v1.1 keeps the track number in the last byte of the comment
+	if data[125] == 0 && data[126] != 0 {
+		tags.Track = int(data[126])
+	}
+	if int(data[127]) < len(id3Genres) {
+		tags.Genre = id3Genres[data[127]]
+	}
+
+	return tags, nil
+}
+
+// readFLACTags reads the VORBIS_COMMENT block from the FLAC metadata that
+// follows the "fLaC" marker. f is positioned just past the first 10 bytes.
+func readFLACTags(f *os.File) (Tags, error) {
+	var tags Tags
+
+	if _, err := f.Seek(4, io.SeekStart); err != nil {
+		return tags, err
+	}
+
+	blockHeader := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(f, blockHeader); err != nil {
+			return tags, errNoTags
+		}
+		last := blockHeader[0]&0x80 != 0
+		blockType := blockHeader[0] & 0x7f
+		length := int(blockHeader[1])<<16 | int(blockHeader[2])<<8 | int(blockHeader[3])
+
+		if blockType == 4 {
+			block := make([]byte, length)
+			if _, err := io.ReadFull(f, block); err != nil {
+				return tags, err
+			}
+			return parseVorbisComments(block)
+		}
+
+		if last {
+			return tags, errNoTags
+		}
+		if _, err := f.Seek(int64(length), io.SeekCurrent); err != nil {
+			return tags, err
+		}
+	}
+}
+
+// parseVorbisComments decodes a Vorbis comment block: a vendor string and a
+// list of KEY=value strings, all length-prefixed little-endian.
+func parseVorbisComments(block []byte) (Tags, error) {
+	var tags Tags
+
+	next := func() (string, bool) {
+		if len(block) < 4 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint32(block))
+		if n > len(block)-4 {
+			return "", false
+		}
+		value := string(block[4 : 4+n])
+		block = block[4+n:]
+		return value, true
+	}
+
+	if _, ok := next(); !ok { // vendor
+		return tags, errNoTags
+	}
+	if len(block) < 4 {
+		return tags, errNoTags
+	}
+	count := int(binary.LittleEndian.Uint32(block))
+	block = block[4:]
+
+	for i := 0; i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			break
+		}
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			tags.Title = value
+		case "ARTIST":
+			tags.Artist = value
+		case "ALBUM":
+			tags.Album = value
+		case "ALBUMARTIST", "ALBUM ARTIST":
+			tags.AlbumArtist = value
+		case "GENRE":
+			tags.Genre = normalizeGenre(value)
+		case "DATE", "YEAR":
+			tags.Date = value
+		case "LABEL", "ORGANIZATION", "PUBLISHER":
+			tags.Label = value
+		case "MUSICBRAINZ_ALBUMID":
+			tags.ReleaseID = value
+		case "TRACKNUMBER":
+			tags.Track = leadingNumber(value)
+		case "DISCNUMBER":
+			disc, total := numberOfTotal(value)
+			tags.Disc = disc
+			if total != 0 {
+				tags.TotalDiscs = total
+			}
+		case "DISCTOTAL", "TOTALDISCS":
+			tags.TotalDiscs = leadingNumber(value)
+		case "COMPILATION":
+			tags.Compilation = value == "1"
+		}
+	}
+
+	return tags, nil
+}
+
+// readOggTags reads the comment header of an Ogg Vorbis or Opus stream: its
+// second packet, which may span several pages.
+func readOggTags(f *os.File) (Tags, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Tags{}, err
+	}
+	r := bufio.NewReader(f)
+
+	var packet []byte
+	packets := 0
+	header := make([]byte, 27)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, []byte("OggS")) {
+			return Tags{}, errNoTags
+		}
+		lacing := make([]byte, header[26])
+		if _, err := io.ReadFull(r, lacing); err != nil {
+			return Tags{}, errNoTags
+		}
+		for _, n := range lacing {
+			segment := make([]byte, n)
+			if _, err := io.ReadFull(r, segment); err != nil {
+				return Tags{}, errNoTags
+			}
+			if packets == 1 {
+				packet = append(packet, segment...)
+				if len(packet) > maxTagSize {
+					return Tags{}, errNoTags
+				}
+			}
+			// A segment shorter than 255 bytes ends its packet
+			if n < 255 {
+				if packets == 1 {
+					return parseOggComments(packet)
+				}
+				packets++
+			}
+		}
+	}
+}
+
+func parseOggComments(packet []byte) (Tags, error) {
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x03vorbis")):
+		return parseVorbisComments(packet[7:])
+	case bytes.HasPrefix(packet, []byte("OpusTags")):
+		return parseVorbisComments(packet[8:])
+	}
+	return Tags{}, errNoTags
+}
+
+// readMP4Tags reads the iTunes-style metadata in moov/udta/meta/ilst.
+func readMP4Tags(f *os.File, size int64) (Tags, error) {
+	moov, err := readMP4Moov(f, size)
+	if err != nil {
+		return Tags{}, errNoTags
+	}
+	ilst, ok := mp4Child(moov, "udta", "meta", "ilst")
+	if !ok {
+		return Tags{}, errNoTags
+	}
+
+	var tags Tags
+	for _, item := range mp4Children(ilst) {
+		if item.name == "----" {
+			// Freeform items are named by a "name" atom next to their data
+			var name string
+			for _, child := range mp4Children(item.body) {
+				if child.name == "name" && len(child.body) >= 4 {
+					name = string(child.body[4:])
+				}
+			}
+			value := mp4Text(item.body)
+			switch strings.ToUpper(name) {
+			case "MUSICBRAINZ ALBUM ID":
+				tags.ReleaseID = value
+			case "LABEL":
+				tags.Label = value
+			}
+			continue
+		}
+
+		data, ok := mp4Data(item.body)
+		if !ok {
+			continue
+		}
+		switch item.name {
+		case "\xa9nam":
+			tags.Title = mp4Text(item.body)
+		case "\xa9ART":
+			tags.Artist = mp4Text(item.body)
+		case "\xa9alb":
+			tags.Album = mp4Text(item.body)
+		case "aART":
+			tags.AlbumArtist = mp4Text(item.body)
+		case "\xa9day":
+			tags.Date = mp4Text(item.body)
+		case "\xa9gen":
+			tags.Genre = normalizeGenre(mp4Text(item.body))
+		case "gnre":
+			// ID3v1 genre numbers, counted from one
+			if len(data) >= 2 {
+				if n := int(binary.BigEndian.Uint16(data)); n >= 1 && n <= len(id3Genres) {
+					tags.Genre = id3Genres[n-1]
+				}
+			}
+		case "cpil":
+			tags.Compilation = len(data) >= 1 && data[len(data)-1] != 0
+		case "trkn":
+			if len(data) >= 4 {
+				tags.Track = int(binary.BigEndian.Uint16(data[2:]))
+			}
+		case "disk":
+			if len(data) >= 4 {
+				tags.Disc = int(binary.BigEndian.Uint16(data[2:]))
+			}
+			if len(data) >= 6 {
+				tags.TotalDiscs = int(binary.BigEndian.Uint16(data[4:]))
+			}
+		}
+	}
+	return tags, nil
+}
+
+// mp4Atom is an atom's name and contents.
+type mp4Atom struct {
+	name string
+	body []byte
+}
+
+// mp4Children splits data into the atoms it holds, stopping at the first
+// malformed one.
+func mp4Children(data []byte) []mp4Atom {
+	var atoms []mp4Atom
+	for len(data) >= 8 {
+		atomSize := int(binary.BigEndian.Uint32(data))
+		if atomSize < 8 || atomSize > len(data) {
+			break
+		}
+		atoms = append(atoms, mp4Atom{name: string(data[4:8]), body: data[8:atomSize]})
+		data = data[atomSize:]
+	}
+	return atoms
+}
+
+// mp4Child follows path down from data. The meta atom carries a version
+// and flags before its children, except in some QuickTime files.
+func mp4Child(data []byte, path ...string) ([]byte, bool) {
+	for _, name := range path {
+		found := false
+		for _, atom := range mp4Children(data) {
+			if atom.name == name {
+				data, found = atom.body, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		if name == "meta" && len(data) >= 8 && string(data[4:8]) != "hdlr" {
+			data = data[4:]
+		}
+	}
+	return data, true
+}
+
+// mp4Data returns the value of an ilst item's data atom, after its type and
+// locale.
+func mp4Data(item []byte) ([]byte, bool) {
+	for _, atom := range mp4Children(item) {
+		if atom.name == "data" && len(atom.body) >= 8 {
+			return atom.body[8:], true
+		}
+	}
+	return nil, false
+}
+
+func mp4Text(item []byte) string {
+	data, _ := mp4Data(item)
+	return strings.TrimSpace(string(data))
+}
+
+// leadingNumber parses values like "3", "03" or "3/12".
+func leadingNumber(value string) int {
+	end := 0
+	for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(value[:end])
+	return n
+}
+
+// mergeAlbum fills album-wide fields of t that are still empty from other,
+// another track of the same album, and keeps the most discs either claims.
+func (t *Tags) mergeAlbum(other Tags) {
+	if t.Genre == "" {
+		t.Genre = other.Genre
+	}
+	if t.Date == "" {
+		t.Date = other.Date
+	}
+	if t.Label == "" {
+		t.Label = other.Label
+	}
+	if t.ReleaseID == "" {
+		t.ReleaseID = other.ReleaseID
+	}
+	t.TotalDiscs = max(t.TotalDiscs, other.TotalDiscs)
+}
+
+// numberOfTotal parses values like "1", "1/2" or "1 of 2" into the number
+// and the total, which is 0 when missing.
+func numberOfTotal(value string) (int, int) {
+	number, rest, ok := strings.Cut(value, "/")
+	if !ok {
+		number, rest, _ = strings.Cut(value, " of ")
+	}
+	return leadingNumber(strings.TrimSpace(number)), leadingNumber(strings.TrimSpace(rest))
+}
+
+// yearFromDate takes the year out of "2004", "2004-05-01", "20040501",
+// "05/01/2004" and the like: the first run of four digits.
+func yearFromDate(date string) int {
+	for i := 0; i+4 <= len(date); i++ {
+		if i > 0 && isDigit(date[i-1]) {
+			continue
+		}
+		if isDigit(date[i]) && isDigit(date[i+1]) && isDigit(date[i+2]) && isDigit(date[i+3]) {
+			year, _ := strconv.Atoi(date[i : i+4])
+			return year
+		}
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// releaseDate is date as "2004", "2004-05" or "2004-05-01", or "" when it is
+// not one of those (ID3v2.4 timestamps lose their time).
+func releaseDate(date string) string {
+	date = strings.TrimSpace(date)
+	date, _, _ = strings.Cut(date, "T")
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		if _, err := time.Parse(layout, date); err == nil {
+			return date
+		}
+	}
+	return ""
+}
+
+// normalizeGenre resolves ID3v1 genre numbers, as ID3v2 writes them: "17",
+// "(17)", or "(17)Rock" where the text refines the number. "((" escapes a
+// genre that really starts with a parenthesis.
+func normalizeGenre(genre string) string {
+	genre = strings.TrimSpace(genre)
+	if strings.HasPrefix(genre, "((") {
+		return genre[1:]
+	}
+	if strings.HasPrefix(genre, "(") {
+		if end := strings.Index(genre, ")"); end != -1 {
+			if refinement := strings.TrimSpace(genre[end+1:]); refinement != "" && !strings.HasPrefix(refinement, "(") {
+				return refinement
+			}
+			genre = genre[1:end]
+		}
+	}
+
+	switch genre {
+	case "RX":
+		return "Remix"
+	case "CR":
+		return "Cover"
+	}
+	if n, err := strconv.Atoi(genre); err == nil {
+		if n >= 0 && n < len(id3Genres) {
+			return id3Genres[n]
+		}
+		return ""
+	}
+	return genre
+}
+
+// id3Genres are the ID3v1 genres by number, with the Winamp extensions.
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass",
+	"Club-House", "Hardcore", "Terror", "Indie", "Britpop", "Afro-Punk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover", "Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "J-Pop", "Synthpop",
+}
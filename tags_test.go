@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// id3v23 builds an ID3v2.3 tag holding the given Latin-1 text frames.
+func id3v23(frames map[string]string) []byte {
+	var body []byte
+	for id, value := range frames {
+		frame := append([]byte{0}, value...)
+		header := make([]byte, 10)
+		copy(header, id)
+		binary.BigEndian.PutUint32(header[4:8], uint32(len(frame)))
+		body = append(body, header...)
+		body = append(body, frame...)
+	}
+	size := len(body)
+	tag := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(tag, body...)
+}
+
+// flacWithComments builds a FLAC header with an empty STREAMINFO block and
+// a Vorbis comment block.
+func flacWithComments(comments []string) []byte {
+	data := []byte("fLaC")
+	data = append(data, 0, 0, 0, 34)
+	data = append(data, make([]byte, 34)...)
+
+	block := vorbisComments(comments)
+	n := len(block)
+	data = append(data, 0x80|4, byte(n>>16), byte(n>>8), byte(n))
+	return append(data, block...)
+}
+
+// vorbisComments builds a Vorbis comment block as FLAC, Ogg Vorbis and Opus
+// files carry it.
+func vorbisComments(comments []string) []byte {
+	var block []byte
+	appendString := func(s string) {
+		block = binary.LittleEndian.AppendUint32(block, uint32(len(s)))
+		block = append(block, s...)
+	}
+	appendString("test")
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(comments)))
+	for _, comment := range comments {
+		appendString(comment)
+	}
+	return block
+}
+
+// oggStream builds an Ogg stream of packets, putting at most two lacing
+// segments on a page so that longer packets span pages.
+func oggStream(packets ...[]byte) []byte {
+	var segments [][]byte
+	for _, packet := range packets {
+		for len(packet) >= 255 {
+			segments = append(segments, packet[:255])
+			packet = packet[255:]
+		}
+		segments = append(segments, packet)
+	}
+
+	var data []byte
+	for len(segments) > 0 {
+		page := segments[:min(2, len(segments))]
+		segments = segments[len(page):]
+		data = append(data, "OggS\x00\x00"...)
+		data = append(data, make([]byte, 20)...)
+		data = append(data, byte(len(page)))
+		for _, segment := range page {
+			data = append(data, byte(len(segment)))
+		}
+		for _, segment := range page {
+			data = append(data, segment...)
+		}
+	}
+	return data
+}
+
+// mp4Item builds an ilst item holding one data atom of the given type.
+func mp4Item(name string, dataType uint32, value []byte) []byte {
+	data := binary.BigEndian.AppendUint32(nil, dataType)
+	data = append(data, 0, 0, 0, 0)
+	return atom(name, atom("data", data, value))
+}
+
+// mp4WithTags builds an M4A file whose moov/udta/meta/ilst holds items.
+func mp4WithTags(items ...[]byte) []byte {
+	hdlr := atom("hdlr", make([]byte, 8), []byte("mdir"), make([]byte, 13))
+	meta := atom("meta", []byte{0, 0, 0, 0}, hdlr, atom("ilst", items...))
+	data := atom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	data = append(data, atom("mdat", make([]byte, 1024))...)
+	return append(data, atom("moov", atom("mvhd", make([]byte, 100)), atom("udta", meta))...)
+}
+
+func TestReadTags(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "tags_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	mp3 := filepath.Join(tempDir, "01.mp3")
+	tag := id3v23(map[string]string{"TPE1": "Artist", "TALB": "Album", "TCON": "Jazz", "TYER": "1959", "TRCK": "3/9"})
+	if err := os.WriteFile(mp3, append(tag, make([]byte, 256)...), 0644); err != nil {
+		t.Fatalf("Failed to write mp3: %v", err)
+	}
+
+	tags, err := readTags(mp3)
+	if err != nil {
+		t.Fatalf("Failed to read ID3v2 tags: %v", err)
+	}
+	if tags.Artist != "Artist" || tags.Album != "Album" || tags.Genre != "Jazz" || yearFromDate(tags.Date) != 1959 || tags.Track != 3 {
+		t.Errorf("Unexpected ID3v2 tags: %+v", tags)
+	}
+
+	// ID3v1 at the end of a file without an ID3v2 header
+	v1 := make([]byte, 128)
+	copy(v1, "TAG")
+	copy(v1[3:], "Old Song")
+	copy(v1[33:], "Old Artist")
+	copy(v1[93:], "1985")
+	v1[126] = 7
+	old := filepath.Join(tempDir, "02.mp3")
+	if err := os.WriteFile(old, append(make([]byte, 512), v1...), 0644); err != nil {
+		t.Fatalf("Failed to write mp3: %v", err)
+	}
+	tags, err = readTags(old)
+	if err != nil {
+		t.Fatalf("Failed to read ID3v1 tags: %v", err)
+	}
+	if tags.Title != "Old Song" || tags.Artist != "Old Artist" || tags.Date != "1985" || tags.Track != 7 {
+		t.Errorf("Unexpected ID3v1 tags: %+v", tags)
+	}
+
+	flac := filepath.Join(tempDir, "03.flac")
+	if err := os.WriteFile(flac, flacWithComments([]string{"ARTIST=Flac Artist", "genre=Ambient", "DATE=2004-05-01", "DISCNUMBER=2/2"}), 0644); err != nil {
+		t.Fatalf("Failed to write flac: %v", err)
+	}
+	tags, err = readTags(flac)
+	if err != nil {
+		t.Fatalf("Failed to read FLAC tags: %v", err)
+	}
+	if tags.Artist != "Flac Artist" || tags.Genre != "Ambient" || yearFromDate(tags.Date) != 2004 || tags.Disc != 2 {
+		t.Errorf("Unexpected FLAC tags: %+v", tags)
+	}
+
+	// Untagged files report no tags
+	if _, err := readTags(filepath.Join(tempDir, "missing.mp3")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+	plain := filepath.Join(tempDir, "04.mp3")
+	os.WriteFile(plain, make([]byte, 512), 0644)
+	if _, err := readTags(plain); err != errNoTags {
+		t.Errorf("Expected errNoTags, got %v", err)
+	}
+}
+
+func TestNormalizeTagValues(t *testing.T) {
+	genres := map[string]string{
+		"17":          "Rock",
+		"(17)":        "Rock",
+		"(17)Britpop": "Britpop",
+		"(8)(26)":     "Jazz",
+		"(RX)":        "Remix",
+		"((Pseudo)":   "(Pseudo)",
+		"Shoegaze":    "Shoegaze",
+		"999":         "",
+	}
+	for value, expected := range genres {
+		if genre := normalizeGenre(value); genre != expected {
+			t.Errorf("Expected genre %q to be %q, got %q", value, expected, genre)
+		}
+	}
+
+	years := map[string]int{"2004": 2004, "2004-05-01": 2004, "20040501": 2004, "05/01/2004": 2004, "2004-05-01T12:00": 2004, "n/a": 0, "123": 0}
+	for date, expected := range years {
+		if year := yearFromDate(date); year != expected {
+			t.Errorf("Expected the year of %q to be %d, got %d", date, expected, year)
+		}
+	}
+	dates := map[string]string{"2004-05-01": "2004-05-01", "2004-05-01T12:00": "2004-05-01", "2004-05": "2004-05", "2004": "2004", "05/01/2004": ""}
+	for date, expected := range dates {
+		if normalized := releaseDate(date); normalized != expected {
+			t.Errorf("Expected %q to normalise to %q, got %q", date, expected, normalized)
+		}
+	}
+
+	for value, expected := range map[string][2]int{"1": {1, 0}, "1/2": {1, 2}, "2 of 3": {2, 3}} {
+		if disc, total := numberOfTotal(value); disc != expected[0] || total != expected[1] {
+			t.Errorf("Expected %q to be disc %v, got %d of %d", value, expected, disc, total)
+		}
+	}
+}
+
+func TestReadAlbumTags(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "tags_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	album := filepath.Join(tempDir, "library", "Blur", "Parklife")
+	createAlbum(t, album, nil)
+	frames := map[string]string{
+		"TPE1": "Blur",
+		"TPE2": "Blur",
+		"TCON": "(132)",
+		"TDRC": "1994-04-25",
+		"TPUB": "Food",
+		"TPOS": "1/2",
+		"TXXX": "MusicBrainz Album Id\x00f5ac4b24-1b6b-4b3a-9a56-a0a1ad1e4e4c",
+	}
+	if err := os.WriteFile(filepath.Join(album, "01.mp3"), append(id3v23(frames), mp3Frames(2, nil)...), 0644); err != nil {
+		t.Fatalf("Failed to write mp3: %v", err)
+	}
+	flac := flacWithComments([]string{"ARTIST=Blur", "GENRE=Britpop", "DATE=1994", "LABEL=Food", "DISCNUMBER=2", "DISCTOTAL=2", "MUSICBRAINZ_ALBUMID=f5ac4b24-1b6b-4b3a-9a56-a0a1ad1e4e4c"})
+	if err := os.WriteFile(filepath.Join(album, "02.flac"), flac, 0644); err != nil {
+		t.Fatalf("Failed to write flac: %v", err)
+	}
+
+	tags, err := readTags(filepath.Join(album, "01.mp3"))
+	if err != nil {
+		t.Fatalf("Failed to read tags: %v", err)
+	}
+	if tags.Genre != "Britpop" || tags.Label != "Food" || tags.Disc != 1 || tags.TotalDiscs != 2 || tags.ReleaseID != "f5ac4b24-1b6b-4b3a-9a56-a0a1ad1e4e4c" {
+		t.Errorf("Unexpected ID3v2 tags: %+v", tags)
+	}
+	tags, err = readTags(filepath.Join(album, "02.flac"))
+	if err != nil {
+		t.Fatalf("Failed to read tags: %v", err)
+	}
+	if tags.Label != "Food" || tags.Disc != 2 || tags.TotalDiscs != 2 || tags.ReleaseID == "" {
+		t.Errorf("Unexpected FLAC tags: %+v", tags)
+	}
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+	albums := server.scanMusicFolders(filepath.Join(tempDir, "library"))
+	if len(albums) != 1 {
+		t.Fatalf("Expected 1 album, got %+v", albums)
+	}
+	scanned := albums[0]
+	if scanned.Year != 1994 || scanned.Date != "1994-04-25" || scanned.Genre != "Britpop" || scanned.AlbumArtist != "Blur" ||
+		scanned.Label != "Food" || scanned.ReleaseID != "f5ac4b24-1b6b-4b3a-9a56-a0a1ad1e4e4c" || scanned.TotalDiscs != 2 {
+		t.Errorf("Unexpected album fields: %+v", scanned)
+	}
+}
+
+func TestReadOggTags(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "tags_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A long comment makes the comment header span pages
+	comments := vorbisComments([]string{
+		"ARTIST=Beck", "ALBUMARTIST=Various Artists", "ALBUM=Summer Hits", "DATE=2004-06-01",
+		"GENRE=Pop", "TRACKNUMBER=3", "DISCNUMBER=1/2", "COMPILATION=1",
+		"DESCRIPTION=" + strings.Repeat("x", 700),
+	})
+	opusHead := append([]byte("OpusHead\x01\x02"), 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0)
+	vorbisHead := append([]byte("\x01vorbis"), make([]byte, 23)...)
+	files := map[string][]byte{
+		"01.opus": oggStream(opusHead, append([]byte("OpusTags"), comments...), make([]byte, 100)),
+		"02.ogg":  oggStream(vorbisHead, append(append([]byte("\x03vorbis"), comments...), 1), make([]byte, 100)),
+	}
+	for name, data := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		tags, err := readTags(path)
+		if err != nil {
+			t.Fatalf("Failed to read tags of %s: %v", name, err)
+		}
+		if tags.Artist != "Beck" || tags.AlbumArtist != "Various Artists" || tags.Album != "Summer Hits" || tags.Date != "2004-06-01" ||
+			tags.Genre != "Pop" || tags.Track != 3 || tags.Disc != 1 || tags.TotalDiscs != 2 || !tags.Compilation {
+			t.Errorf("Unexpected tags in %s: %+v", name, tags)
+		}
+	}
+}
+
+func TestReadMP4Tags(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "tags_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	freeform := func(name, value string) []byte {
+		return atom("----", atom("mean", []byte{0, 0, 0, 0}, []byte("com.apple.iTunes")),
+			atom("name", []byte{0, 0, 0, 0}, []byte(name)), atom("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(value)))
+	}
+	m4a := mp4WithTags(
+		mp4Item("\xa9nam", 1, []byte("Teardrop")),
+		mp4Item("\xa9ART", 1, []byte("Massive Attack")),
+		mp4Item("\xa9alb", 1, []byte("Mezzanine")),
+		mp4Item("aART", 1, []byte("Various Artists")),
+		mp4Item("\xa9day", 1, []byte("1998-04-20")),
+		mp4Item("\xa9gen", 1, []byte("Trip-Hop")),
+		mp4Item("cpil", 21, []byte{1}),
+		mp4Item("trkn", 0, []byte{0, 0, 0, 3, 0, 11, 0, 0}),
+		mp4Item("disk", 0, []byte{0, 0, 0, 2, 0, 2}),
+		freeform("MusicBrainz Album Id", "f5ac4b24-1b6b-4b3a-9a56-a0a1ad1e4e4c"),
+		freeform("LABEL", "Virgin"),
+	)
+	path := filepath.Join(tempDir, "03.m4a")
+	if err := os.WriteFile(path, m4a, 0644); err != nil {
+		t.Fatalf("Failed to write m4a: %v", err)
+	}
+
+	tags, err := readTags(path)
+	if err != nil {
+		t.Fatalf("Failed to read tags: %v", err)
+	}
+	if tags.Title != "Teardrop" || tags.Artist != "Massive Attack" || tags.Album != "Mezzanine" || tags.AlbumArtist != "Various Artists" ||
+		tags.Date != "1998-04-20" || tags.Genre != "Trip-Hop" || !tags.Compilation || tags.Track != 3 || tags.Disc != 2 || tags.TotalDiscs != 2 ||
+		tags.ReleaseID != "f5ac4b24-1b6b-4b3a-9a56-a0a1ad1e4e4c" || tags.Label != "Virgin" {
+		t.Errorf("Unexpected MP4 tags: %+v", tags)
+	}
+
+	// Numbered genres are ID3v1 genres counted from one
+	if err := os.WriteFile(path, mp4WithTags(mp4Item("gnre", 0, []byte{0, 10})), 0644); err != nil {
+		t.Fatalf("Failed to write m4a: %v", err)
+	}
+	if tags, _ := readTags(path); tags.Genre != "Metal" {
+		t.Errorf("Expected genre 10 to be Metal, got %q", tags.Genre)
+	}
+}
+
+func TestReadTagsWithOversizedID3Header(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "tags_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The header claims the largest size a syncsafe integer can hold; only
+	// what the file has is read
+	tag := id3v23(map[string]string{"TPE1": "Artist"})
+	copy(tag[6:10], []byte{0x7f, 0x7f, 0x7f, 0x7f})
+	path := filepath.Join(tempDir, "01.mp3")
+	if err := os.WriteFile(path, append(tag, mp3Frames(2, nil)...), 0644); err != nil {
+		t.Fatalf("Failed to write mp3: %v", err)
+	}
+
+	tags, err := readTags(path)
+	if err != nil || tags.Artist != "Artist" {
+		t.Errorf("Expected the frames in the file to be read, got %+v, %v", tags, err)
+	}
+}
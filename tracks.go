@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Track is one audio file of an album with its tags and stream details.
+type Track struct {
+	Name        string  `json:"name"`
+	TrackNumber int     `json:"track_number,omitempty"`
+	DiscNumber  int     `json:"disc_number,omitempty"`
+	Title       string  `json:"title"`
+	Artist      string  `json:"artist,omitempty"`
+	DurationSec float64 `json:"duration_sec"`
+	BitrateKbps int     `json:"bitrate_kbps,omitempty"`
+	SampleRate  int     `json:"sample_rate,omitempty"`
+	Codec       string  `json:"codec"`
+	SizeBytes   int64   `json:"size_bytes"`
+}
+
+// readTrack describes the audio file at path. Missing tags leave the title
+// as the file name; unreadable headers leave the duration at zero.
+func readTrack(path string, size int64) Track {
+	tags, info := readTrackFile(path)
+	return newTrack(filepath.Base(path), size, tags, info)
+}
+
+// readTrackFile reads the tags and stream details of the audio file at path,
+// opening it only once. Either is left empty where it cannot be read, the
+// stream details then falling back to the format the extension claims.
+func readTrackFile(path string) (Tags, AudioInfo) {
+	f, err := os.Open(path)
+	if err != nil {
+		claimed := formatFromExtension(path)
+		return Tags{}, AudioInfo{Format: claimed, Codec: claimed}
+	}
+	defer f.Close()
+
+	info, _ := readAudioInfoFrom(f)
+	tags, _ := readTagsFrom(f)
+	return tags, info
+}
+
+// newTrack describes the audio file name from tags and info already read.
+func newTrack(name string, size int64, tags Tags, info AudioInfo) Track {
+	track := Track{
+		Name:        name,
+		TrackNumber: tags.Track,
+		DiscNumber:  tags.Disc,
+		Title:       tags.Title,
+		Artist:      tags.Artist,
+		DurationSec: info.DurationSec,
+		BitrateKbps: info.BitrateKbps,
+		SampleRate:  info.SampleRate,
+		Codec:       info.Codec,
+		SizeBytes:   size,
+	}
+	if track.Title == "" {
+		track.Title = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return track
+}
+
+// sortTracks orders tracks by disc and track number, then by name.
+func sortTracks(tracks []Track) {
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, b := tracks[i], tracks[j]
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+		if a.TrackNumber != b.TrackNumber {
+			return a.TrackNumber < b.TrackNumber
+		}
+		return a.Name < b.Name
+	})
+}
+
+// albumTracks reads the audio files in albumPath and its disc folders,
+// ordered by disc and track number, then by name. Tracks in a disc folder
+// are named like "CD1/01.mp3" and are on that folder's disc.
+func albumTracks(albumPath string) ([]Track, error) {
+	entries, err := os.ReadDir(albumPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := []Track{}
+	addTracks := func(dir, prefix string, entries []os.DirEntry, disc int) {
+		for _, entry := range entries {
+			if entry.IsDir() || !isAudioFile(entry.Name()) {
+				continue
+			}
+			var size int64
+			if info, err := entry.Info(); err == nil {
+				size = info.Size()
+			}
+			track := readTrack(filepath.Join(dir, entry.Name()), size)
+			track.Name = prefix + track.Name
+			if disc != 0 {
+				track.DiscNumber = disc
+			}
+			tracks = append(tracks, track)
+		}
+	}
+
+	addTracks(albumPath, "", entries, 0)
+	for _, entry := range entries {
+		disc, ok := discNumber(entry.Name())
+		if !entry.IsDir() || !ok {
+			continue
+		}
+		discPath := filepath.Join(albumPath, entry.Name())
+		discEntries, err := os.ReadDir(discPath)
+		if err != nil {
+			continue
+		}
+		addTracks(discPath, entry.Name()+"/", discEntries, disc)
+	}
+
+	sortTracks(tracks)
+	return tracks, nil
+}
+
+// scannedAlbum finds an album of the last scan by its fingerprint.
+func (s *Server) scannedAlbum(fingerprint string) (AlbumFolder, bool) {
+	s.lastScanMutex.RLock()
+	defer s.lastScanMutex.RUnlock()
+
+	for _, album := range s.lastScan {
+		if album.Fingerprint == fingerprint {
+			return album, true
+		}
+	}
+	return AlbumFolder{}, false
+}
+
+// handleAlbum serves /api/albums/{fingerprint}/tracks for albums of the last
+// scan.
+func (s *Server) handleAlbum(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/albums/"), "/tracks")
+	if !ok || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	album, ok := s.scannedAlbum(id)
+	if !ok {
+		http.Error(w, "Album not found in the last scan", http.StatusNotFound)
+		return
+	}
+
+	// The scan keeps each album's tracks; only albums scanned without them
+	// are read from disk
+	tracks := album.Tracks
+	if tracks == nil {
+		var err error
+		tracks, err = albumTracks(album.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tracks)
+}
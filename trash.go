@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Unsynced albums are moved here instead of being deleted, until the trash is
+// purged.
+const trashDirName = ".music-sync-trash"
+
+// errUnmanagedAlbum refuses to remove a folder music-sync did not create.
+var errUnmanagedAlbum = errors.New("was not synced by music-sync, refusing to remove it")
+
+// unsyncAlbum moves a folder music-sync created on the target into the trash.
+// Folders that are not in the manifest are refused. With adopt set, an exact
+// copy of sourcePath (an album synced before the manifest existed) is taken
+// over and removed too. A dry run only returns the plan.
+func (s *Server) unsyncAlbum(targetDirectory, folder, sourcePath string, adopt, dryRun bool) (TrashedAlbum, SyncPlan, error) {
+	plan := newSyncPlan()
+
+	s.manifestMutex.Lock()
+	defer s.manifestMutex.Unlock()
+
+	manifest, err := loadManifest(targetDirectory)
+	if err != nil {
+		return TrashedAlbum{}, plan, err
+	}
+
+	// The album may have been synced under a renamed folder
+	if sourcePath != "" {
+		if entry, ok := manifest.findBySource(sourcePath); ok {
+			folder = filepath.FromSlash(entry.Folder)
+		}
+	}
+
+	folderPath, err := targetFolderPath(targetDirectory, folder)
+	if err != nil {
+		return TrashedAlbum{}, plan, err
+	}
+
+	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
+		return TrashedAlbum{}, plan, fmt.Errorf("album %s not found in target directory", folder)
+	}
+
+	entry, managed := manifest.find(folder)
+	if !managed && adopt && sourcePath != "" {
+		sourceFingerprint := s.generateFolderFingerprint(sourcePath)
+		if sourceFingerprint != "" && s.generateFolderFingerprint(folderPath) == sourceFingerprint {
+			entry = SyncedAlbum{
+				Folder:      manifestFolder(folder),
+				SourcePath:  sourcePath,
+				Fingerprint: sourceFingerprint,
+			}
+			managed = true
+		}
+	}
+	if !managed {
+		return TrashedAlbum{}, plan, fmt.Errorf("album %s %w", folder, errUnmanagedAlbum)
+	}
+
+	plan.Deletes, err = trashOps(targetDirectory, folderPath)
+	if err != nil {
+		return TrashedAlbum{}, plan, err
+	}
+	if dryRun {
+		return TrashedAlbum{SyncedAlbum: entry}, plan, nil
+	}
+
+	trashed, err := s.moveToTrash(targetDirectory, &manifest, entry, folderPath)
+	if err != nil {
+		return TrashedAlbum{}, plan, err
+	}
+	if err := saveManifest(targetDirectory, manifest); err != nil {
+		return TrashedAlbum{}, plan, err
+	}
+
+	return trashed, plan, nil
+}
+
+// moveToTrash moves folderPath into the trash and updates manifest, which the
+// caller must hold the lock for and save. Callers decide whether the folder
+// may be removed.
+func (s *Server) moveToTrash(targetDirectory string, manifest *TargetManifest, entry SyncedAlbum, folderPath string) (TrashedAlbum, error) {
+	trashDir := filepath.Join(targetDirectory, trashDirName)
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return TrashedAlbum{}, fmt.Errorf("failed to create trash folder: %v", err)
+	}
+
+	now := time.Now()
+	trashFolder := uniqueTrashFolder(trashDir, now.Format("20060102-150405")+"-"+strings.ReplaceAll(manifestFolder(entry.Folder), "/", "_"))
+	if err := os.Rename(folderPath, filepath.Join(trashDir, trashFolder)); err != nil {
+		return TrashedAlbum{}, fmt.Errorf("failed to move album to trash: %v", err)
+	}
+
+	trashed := TrashedAlbum{
+		SyncedAlbum: entry,
+		TrashFolder: trashFolder,
+		TrashedAt:   now,
+	}
+	manifest.remove(entry.Folder)
+	manifest.Trash = append(manifest.Trash, trashed)
+
+	s.invalidateFingerprint(folderPath)
+
+	return trashed, nil
+}
+
+func uniqueTrashFolder(trashDir, name string) string {
+	candidate := name
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(trashDir, candidate)); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+}
+
+// restoreAlbum moves a trashed album back to where it was. An empty
+// trashFolder restores the most recently removed album.
+func (s *Server) restoreAlbum(targetDirectory, trashFolder string) (SyncedAlbum, error) {
+	s.manifestMutex.Lock()
+	defer s.manifestMutex.Unlock()
+
+	manifest, err := loadManifest(targetDirectory)
+	if err != nil {
+		return SyncedAlbum{}, err
+	}
+	if len(manifest.Trash) == 0 {
+		return SyncedAlbum{}, errors.New("trash is empty")
+	}
+
+	index := len(manifest.Trash) - 1
+	if trashFolder != "" {
+		index = -1
+		for i, trashed := range manifest.Trash {
+			if trashed.TrashFolder == trashFolder {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return SyncedAlbum{}, fmt.Errorf("%s not found in trash", trashFolder)
+		}
+	}
+	trashed := manifest.Trash[index]
+
+	folderPath, err := targetFolderPath(targetDirectory, trashed.Folder)
+	if err != nil {
+		return SyncedAlbum{}, err
+	}
+	if _, err := os.Stat(folderPath); err == nil {
+		return SyncedAlbum{}, fmt.Errorf("cannot restore %s: folder already exists", trashed.Folder)
+	}
+	if err := os.MkdirAll(filepath.Dir(folderPath), 0755); err != nil {
+		return SyncedAlbum{}, fmt.Errorf("failed to restore album: %v", err)
+	}
+	if err := os.Rename(filepath.Join(targetDirectory, trashDirName, trashed.TrashFolder), folderPath); err != nil {
+		return SyncedAlbum{}, fmt.Errorf("failed to restore album: %v", err)
+	}
+
+	manifest.Trash = append(manifest.Trash[:index], manifest.Trash[index+1:]...)
+	// Folders that were replaced on conflict were never ours, keep them unmanaged
+	if trashed.SourcePath != "" {
+		manifest.record(trashed.SyncedAlbum)
+	}
+	if err := saveManifest(targetDirectory, manifest); err != nil {
+		return SyncedAlbum{}, err
+	}
+
+	return trashed.SyncedAlbum, nil
+}
+
+// purgeTrash permanently deletes everything in the target's trash and returns
+// how many albums were removed.
+func (s *Server) purgeTrash(targetDirectory string) (int, error) {
+	s.manifestMutex.Lock()
+	defer s.manifestMutex.Unlock()
+
+	manifest, err := loadManifest(targetDirectory)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.RemoveAll(filepath.Join(targetDirectory, trashDirName)); err != nil {
+		return 0, fmt.Errorf("failed to purge trash: %v", err)
+	}
+
+	purged := len(manifest.Trash)
+	manifest.Trash = nil
+	if err := saveManifest(targetDirectory, manifest); err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+func (s *Server) handleUndoUnsync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		TargetDirectory string `json:"targetDirectory"`
+		TrashFolder     string `json:"trashFolder"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	targetDirectory, err := resolveAllowed(req.TargetDirectory, s.targetRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+
+	restored, err := s.restoreAlbum(targetDirectory, req.TrashFolder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"result": fmt.Sprintf("Restored %s", restored.Folder)})
+}
+
+func (s *Server) handleTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	targetDirectory, err := resolveAllowed(r.URL.Query().Get("targetDirectory"), s.targetRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+
+	manifest, err := loadManifest(targetDirectory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	trash := manifest.Trash
+	if trash == nil {
+		trash = []TrashedAlbum{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trash)
+}
+
+func (s *Server) handlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		TargetDirectory string `json:"targetDirectory"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	targetDirectory, err := resolveAllowed(req.TargetDirectory, s.targetRoots())
+	if err != nil {
+		writePathError(w, err)
+		return
+	}
+
+	purged, err := s.purgeTrash(targetDirectory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createAlbum makes a folder with empty files for tests.
+func createAlbum(t *testing.T, dir string, files []string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create folder %s: %v", dir, err)
+	}
+	for _, file := range files {
+		f, err := os.Create(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("Failed to create test file %s: %v", file, err)
+		}
+		f.Close()
+	}
+}
+
+func TestUnsyncOnlyRemovesManagedFolders(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "unsync_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sourceAlbum := filepath.Join(tempDir, "source", "TestAlbum")
+	targetDir := filepath.Join(tempDir, "target")
+	createAlbum(t, sourceAlbum, []string{"track1.mp3", "track2.mp3"})
+	createAlbum(t, filepath.Join(targetDir, "Manual"), []string{"song.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	if _, err := server.syncAlbum(sourceAlbum, targetDir, SyncOptions{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// Names escaping the target are refused
+	for _, name := range []string{"..", "../source", "/etc", ".music-sync-trash"} {
+		if _, _, err := server.unsyncAlbum(targetDir, name, "", false, false); err == nil {
+			t.Errorf("Expected unsync of %q to be refused", name)
+		}
+	}
+
+	// Folders music-sync did not create are left alone
+	if _, _, err := server.unsyncAlbum(targetDir, "Manual", "", false, false); !errors.Is(err, errUnmanagedAlbum) {
+		t.Errorf("Expected unsync of unmanaged folder to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "Manual")); err != nil {
+		t.Errorf("Expected unmanaged folder to still exist: %v", err)
+	}
+
+	trashed, _, err := server.unsyncAlbum(targetDir, "TestAlbum", "", false, false)
+	if err != nil {
+		t.Fatalf("Unsync failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "TestAlbum")); !os.IsNotExist(err) {
+		t.Error("Expected album to be moved out of the target")
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, trashDirName, trashed.TrashFolder, "track1.mp3")); err != nil {
+		t.Errorf("Expected album in trash: %v", err)
+	}
+
+	// Undo puts it back and records it as synced again
+	if _, err := server.restoreAlbum(targetDir, ""); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "TestAlbum", "track1.mp3")); err != nil {
+		t.Errorf("Expected album to be restored: %v", err)
+	}
+	manifest, err := loadManifest(targetDir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if _, ok := manifest.find("TestAlbum"); !ok || len(manifest.Trash) != 0 {
+		t.Errorf("Expected restored album in manifest and empty trash, got %+v", manifest)
+	}
+}
+
+func TestUnsyncAdoptsIdenticalCopy(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "adopt_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Copy made by an older version without a manifest
+	files := []string{"track1.mp3", "cover.jpg"}
+	sourceAlbum := filepath.Join(tempDir, "source", "OldAlbum")
+	targetDir := filepath.Join(tempDir, "target")
+	createAlbum(t, sourceAlbum, files)
+	createAlbum(t, filepath.Join(targetDir, "OldAlbum"), files)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+	}
+
+	// Without adopt, a folder missing from the manifest is refused
+	if _, _, err := server.unsyncAlbum(targetDir, "OldAlbum", sourceAlbum, false, false); err == nil {
+		t.Fatalf("Expected an unrecorded copy to be refused without adopt")
+	}
+	if _, _, err := server.unsyncAlbum(targetDir, "OldAlbum", sourceAlbum, true, false); err != nil {
+		t.Fatalf("Expected identical copy to be removable, got %v", err)
+	}
+
+	purged, err := server.purgeTrash(targetDir)
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected 1 purged album, got %d", purged)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, trashDirName)); !os.IsNotExist(err) {
+		t.Error("Expected trash folder to be removed")
+	}
+}
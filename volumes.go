@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Volume is a mounted filesystem.
+type Volume struct {
+	Device    string `json:"device"`
+	MountPath string `json:"mountPath"`
+	FSType    string `json:"fsType"`
+	Label     string `json:"label,omitempty"`
+	UUID      string `json:"uuid,omitempty"`
+}
+
+// DevicePresence reports where a device profile is currently mounted, if
+// anywhere, and how it was recognised.
+type DevicePresence struct {
+	Name      string `json:"name"`
+	Present   bool   `json:"present"`
+	MountPath string `json:"mountPath,omitempty"`
+	MatchedBy string `json:"matchedBy,omitempty"`
+}
+
+// deviceMarkerFile is written to a device's root so it can be recognised on
+// systems without labels or UUIDs.
+const deviceMarkerFile = ".music-sync-device.json"
+
+type deviceMarker struct {
+	Name string `json:"name"`
+}
+
+// parseMountinfo reads the /proc/self/mountinfo format:
+//
+//	36 35 98:0 /mnt1 /mnt/parent rw,noatime master:1 - ext3 /dev/root rw
+func parseMountinfo(r io.Reader) []Volume {
+	var volumes []Volume
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		separator := -1
+		for i, field := range fields {
+			if field == "-" {
+				separator = i
+				break
+			}
+		}
+		if separator < 5 || len(fields) < separator+3 {
+			continue
+		}
+		volumes = append(volumes, Volume{
+			Device:    unescapeOctal(fields[separator+2]),
+			MountPath: unescapeOctal(fields[4]),
+			FSType:    fields[separator+1],
+		})
+	}
+	return volumes
+}
+
+// unescapeOctal undoes the \040-style escaping mountinfo uses for spaces,
+// tabs, newlines and backslashes.
+func unescapeOctal(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// unescapeHex undoes the \x20-style escaping udev uses in /dev/disk/by-label.
+func unescapeHex(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.HasPrefix(s[i:], `\x`) && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// diskLinks maps device nodes to the names of their symlinks in dir, such as
+// /dev/disk/by-label.
+func diskLinks(dir string) map[string]string {
+	links := make(map[string]string)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return links
+	}
+	for _, entry := range entries {
+		device, err := filepath.EvalSymlinks(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		links[device] = unescapeHex(entry.Name())
+	}
+	return links
+}
+
+// listVolumes returns the mounted filesystems with their labels and UUIDs.
+// It is empty where /proc/self/mountinfo does not exist.
+func listVolumes() []Volume {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	volumes := parseMountinfo(f)
+	labels := diskLinks("/dev/disk/by-label")
+	uuids := diskLinks("/dev/disk/by-uuid")
+	for i := range volumes {
+		device := volumes[i].Device
+		if resolved, err := filepath.EvalSymlinks(device); err == nil {
+			device = resolved
+		}
+		volumes[i].Label = labels[device]
+		volumes[i].UUID = uuids[device]
+	}
+	return volumes
+}
+
+func readDeviceMarker(mountPath string) (deviceMarker, bool) {
+	var marker deviceMarker
+	data, err := os.ReadFile(filepath.Join(mountPath, deviceMarkerFile))
+	if err != nil || json.Unmarshal(data, &marker) != nil {
+		return marker, false
+	}
+	return marker, marker.Name != ""
+}
+
+func writeDeviceMarker(mountPath, name string) error {
+	data, err := json.MarshalIndent(deviceMarker{Name: name}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(mountPath, deviceMarkerFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write device marker: %v", err)
+	}
+	return nil
+}
+
+// markerCandidates are the directories checked for a device marker: every
+// mounted volume and the folders directly inside the allowed target roots,
+// where devices are usually mounted.
+func markerCandidates(volumes []Volume, roots []string) []string {
+	var candidates []string
+	for _, volume := range volumes {
+		candidates = append(candidates, volume.MountPath)
+	}
+	for _, root := range roots {
+		candidates = append(candidates, root)
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				candidates = append(candidates, filepath.Join(root, entry.Name()))
+			}
+		}
+	}
+	return candidates
+}
+
+// isMountPoint reports whether path is one of the mounted volumes. Without
+// a mount table it can only check that the folder exists.
+func isMountPoint(path string, volumes []Volume) bool {
+	if len(volumes) == 0 {
+		info, err := os.Stat(path)
+		return err == nil && info.IsDir()
+	}
+	path = filepath.Clean(path)
+	for _, volume := range volumes {
+		if filepath.Clean(volume.MountPath) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// locateDevice finds where device is mounted: by UUID, then label, then a
+// marker file naming it, and finally its saved mount path if something is
+// mounted there. An unmounted device usually leaves its mount point behind
+// as an empty folder, which does not count.
+func locateDevice(device DeviceProfile, volumes []Volume, candidates []string) DevicePresence {
+	presence := DevicePresence{Name: device.Name}
+	found := func(mountPath, matchedBy string) DevicePresence {
+		presence.Present, presence.MountPath, presence.MatchedBy = true, mountPath, matchedBy
+		return presence
+	}
+
+	if device.VolumeUUID != "" {
+		for _, volume := range volumes {
+			if strings.EqualFold(volume.UUID, device.VolumeUUID) {
+				return found(volume.MountPath, "uuid")
+			}
+		}
+	}
+	if device.VolumeLabel != "" {
+		for _, volume := range volumes {
+			if volume.Label == device.VolumeLabel {
+				return found(volume.MountPath, "label")
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		if marker, ok := readDeviceMarker(candidate); ok && marker.Name == device.Name {
+			return found(candidate, "marker")
+		}
+	}
+	if device.MountPath != "" && isMountPoint(device.MountPath, volumes) {
+		return found(device.MountPath, "path")
+	}
+	return presence
+}
+
+// devicePresence reports every device profile's current mount point.
+func (s *Server) devicePresence(devices []DeviceProfile) []DevicePresence {
+	volumes := listVolumes()
+	candidates := markerCandidates(volumes, s.targetRoots())
+
+	presence := make([]DevicePresence, 0, len(devices))
+	for _, device := range devices {
+		presence = append(presence, locateDevice(device, volumes, candidates))
+	}
+	return presence
+}
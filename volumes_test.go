@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseMountinfo(t *testing.T) {
+	mountinfo := `22 1 8:2 / / rw,relatime shared:1 - ext4 /dev/sda2 rw
+45 22 8:17 / /media/anna/My\040Player rw,nosuid shared:40 - vfat /dev/sdb1 rw,uid=1000
+46 22 0:5 / /proc rw - proc proc rw
+`
+	volumes := parseMountinfo(strings.NewReader(mountinfo))
+	if len(volumes) != 3 {
+		t.Fatalf("Expected 3 volumes, got %d", len(volumes))
+	}
+	player := volumes[1]
+	if player.MountPath != "/media/anna/My Player" || player.Device != "/dev/sdb1" || player.FSType != "vfat" {
+		t.Errorf("Unexpected volume: %+v", player)
+	}
+
+	if label := unescapeHex(`My\x20Player`); label != "My Player" {
+		t.Errorf("Expected escaped label to be decoded, got %q", label)
+	}
+}
+
+func TestLocateDevice(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "volumes_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	usb := filepath.Join(tempDir, "USB1")
+	unlabelled := filepath.Join(tempDir, "disk")
+	unmounted := filepath.Join(tempDir, "usb")
+	createAlbum(t, usb, nil)
+	createAlbum(t, unlabelled, nil)
+	createAlbum(t, unmounted, nil)
+	if err := writeDeviceMarker(unlabelled, "Kids"); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+
+	volumes := []Volume{
+		{Device: "/dev/sdb1", MountPath: usb, Label: "WALKMAN", UUID: "1A2B-3C4D"},
+		{Device: "/dev/sdc1", MountPath: unlabelled},
+	}
+	candidates := markerCandidates(volumes, nil)
+
+	tests := []struct {
+		device    DeviceProfile
+		mountPath string
+		matchedBy string
+	}{
+		// The saved path went stale but the UUID still finds the device
+		{DeviceProfile{Name: "Car", MountPath: filepath.Join(tempDir, "USB"), VolumeUUID: "1a2b-3c4d"}, usb, "uuid"},
+		{DeviceProfile{Name: "Walkman", VolumeLabel: "WALKMAN"}, usb, "label"},
+		{DeviceProfile{Name: "Kids", VolumeLabel: "KIDS"}, unlabelled, "marker"},
+		{DeviceProfile{Name: "Folder", MountPath: usb}, usb, "path"},
+		// The empty folder an unmounted device leaves behind is not the device
+		{DeviceProfile{Name: "Unplugged", MountPath: unmounted}, "", ""},
+		{DeviceProfile{Name: "Away", VolumeLabel: "AWAY", MountPath: filepath.Join(tempDir, "gone")}, "", ""},
+	}
+	for _, test := range tests {
+		presence := locateDevice(test.device, volumes, candidates)
+		if presence.MountPath != test.mountPath || presence.MatchedBy != test.matchedBy || presence.Present != (test.mountPath != "") {
+			t.Errorf("%s: expected %q by %q, got %+v", test.device.Name, test.mountPath, test.matchedBy, presence)
+		}
+	}
+
+	// Without a mount table the folders inside the target roots are searched
+	candidates = markerCandidates(nil, []string{tempDir})
+	if presence := locateDevice(DeviceProfile{Name: "Kids"}, nil, candidates); presence.MountPath != unlabelled {
+		t.Errorf("Expected marker to be found under the target root, got %+v", presence)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// libraryPollInterval is how often the polling watcher walks the library.
+	libraryPollInterval = 10 * time.Second
+	// libraryChangeDelay lets a burst of changes (copying an album) settle
+	// before the affected albums are rescanned.
+	libraryChangeDelay = 2 * time.Second
+)
+
+// libraryChange asks for path to be rescanned; Recursive includes every
+// folder below it, for folders that were created, moved or deleted.
+type libraryChange struct {
+	Path      string
+	Recursive bool
+}
+
+// libraryWatcher reports changes below a library root on its changes
+// channel, which it closes once Close has stopped it.
+type libraryWatcher interface {
+	Close() error
+}
+
+var errNativeWatchUnsupported = errors.New("native filesystem watching is not supported on this platform")
+
+// watchLibrary starts watching root for changes that keep lastScan current,
+// replacing any watcher on a previous root. Native watching is used where
+// available, polling otherwise.
+func (s *Server) watchLibrary(root string) {
+	s.watcherMutex.Lock()
+	defer s.watcherMutex.Unlock()
+
+	if s.libraryWatcher != nil {
+		if s.watchedRoot == root {
+			return
+		}
+		s.libraryWatcher.Close()
+		s.libraryWatcher = nil
+	}
+
+	changes := make(chan libraryChange, 256)
+	watcher, err := newNativeWatcher(root, changes)
+	if err != nil {
+		log.Printf("Watching %s by polling: %v", root, err)
+		watcher = newPollingWatcher(root, libraryPollInterval, changes)
+	}
+	s.libraryWatcher = watcher
+	s.watchedRoot = root
+
+	go s.applyLibraryChanges(root, changes, libraryChangeDelay)
+}
+
+// stopWatchingLibrary stops the library watcher, if any.
+func (s *Server) stopWatchingLibrary() {
+	s.watcherMutex.Lock()
+	defer s.watcherMutex.Unlock()
+
+	if s.libraryWatcher != nil {
+		s.libraryWatcher.Close()
+		s.libraryWatcher = nil
+		s.watchedRoot = ""
+	}
+}
+
+// applyLibraryChanges collects changes until none arrive for delay, then
+// rescans the affected folders. It returns when changes is closed.
+func (s *Server) applyLibraryChanges(root string, changes <-chan libraryChange, delay time.Duration) {
+	pending := make(map[libraryChange]bool)
+	timer := time.NewTimer(delay)
+	timer.Stop()
+
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			pending[change] = true
+			timer.Reset(delay)
+		case <-timer.C:
+			for change := range pending {
+				s.refreshLibraryPath(root, change.Path, change.Recursive)
+			}
+			pending = make(map[libraryChange]bool)
+		}
+	}
+}
+
+// refreshLibraryPath rescans path (and with recursive, everything below it)
+// and updates lastScan, publishing album-added, album-changed and
+// album-removed events. Nothing is updated when lastScan is no longer a scan
+// of root.
+func (s *Server) refreshLibraryPath(root, path string, recursive bool) {
+	if !isWithin(root, path) {
+		return
+	}
+	// A disc folder changes the album it belongs to, which is read with
+	// all its discs
+	if _, ok := discNumber(filepath.Base(path)); ok && filepath.Dir(path) != root {
+		path, recursive = filepath.Dir(path), false
+	}
+	affected := func(albumPath string) bool {
+		return albumPath == path || (recursive && isWithin(path, albumPath))
+	}
+
+	// Files changed, so cached fingerprints are stale
+	s.cacheMutex.Lock()
+	for cached := range s.fingerprintCache {
+		if affected(cached) {
+			delete(s.fingerprintCache, cached)
+		}
+	}
+	s.cacheMutex.Unlock()
+
+	found := make(map[string]AlbumFolder)
+	if path != root {
+		if album, ok := s.albumFolderIn(root, path); ok {
+			found[album.Path] = album
+		}
+	}
+	if recursive {
+		albums, _ := s.scanLibrary(context.Background(), root, path, nil, nil)
+		for _, album := range albums {
+			found[album.Path] = album
+		}
+	}
+
+	var added, changed, removed []AlbumFolder
+
+	s.lastScanMutex.Lock()
+	// A scan of another folder replaced the last scan while this ran, so
+	// these albums no longer belong in it
+	if s.lastScanRoot != root {
+		s.lastScanMutex.Unlock()
+		return
+	}
+	albums := make([]AlbumFolder, 0, len(s.lastScan)+len(found))
+	for _, album := range s.lastScan {
+		if !affected(album.Path) {
+			albums = append(albums, album)
+			continue
+		}
+		current, ok := found[album.Path]
+		if !ok {
+			removed = append(removed, album)
+			continue
+		}
+		delete(found, album.Path)
+		if albumChanged(album, current) {
+			changed = append(changed, current)
+		}
+		albums = append(albums, current)
+	}
+	for _, album := range found {
+		added = append(added, album)
+		albums = append(albums, album)
+	}
+	if len(added) > 0 {
+		sort.SliceStable(albums, func(i, j int) bool { return albums[i].Path < albums[j].Path })
+		sort.Slice(added, func(i, j int) bool { return added[i].Path < added[j].Path })
+	}
+	s.lastScan = albums
+	s.lastScanMutex.Unlock()
+
+	for _, album := range added {
+		s.publish("album-added", album)
+	}
+	for _, album := range changed {
+		s.publish("album-changed", album)
+	}
+	for _, album := range removed {
+		s.publish("album-removed", map[string]string{"path": album.Path, "fingerprint": album.Fingerprint})
+	}
+}
+
+func albumChanged(old, current AlbumFolder) bool {
+	return old.Fingerprint != current.Fingerprint || old.SizeMB != current.SizeMB ||
+		old.Mp3Count != current.Mp3Count || old.HasCover != current.HasCover ||
+		old.Genre != current.Genre || old.Year != current.Year || old.Format != current.Format ||
+		old.DurationSec != current.DurationSec || old.Codec != current.Codec ||
+		old.Compilation != current.Compilation || old.AlbumArtist != current.AlbumArtist ||
+		old.Date != current.Date || old.Label != current.Label || old.ReleaseID != current.ReleaseID
+}
+
+// pollingWatcher finds changes by walking the library every interval and
+// comparing each folder's files with the previous walk.
+type pollingWatcher struct {
+	stop chan struct{}
+	once sync.Once
+}
+
+func newPollingWatcher(root string, interval time.Duration, changes chan<- libraryChange) *pollingWatcher {
+	w := &pollingWatcher{stop: make(chan struct{})}
+	previous := snapshotLibrary(root)
+
+	go func() {
+		defer close(changes)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+
+			current := snapshotLibrary(root)
+			for dir, signature := range current {
+				if previous[dir] != signature {
+					changes <- libraryChange{Path: dir}
+				}
+			}
+			for dir := range previous {
+				if _, ok := current[dir]; !ok {
+					changes <- libraryChange{Path: dir}
+				}
+			}
+			previous = current
+		}
+	}()
+
+	return w
+}
+
+func (w *pollingWatcher) Close() error {
+	w.once.Do(func() { close(w.stop) })
+	return nil
+}
+
+// snapshotLibrary maps every folder below root to a signature of the names,
+// sizes and modification times of the files directly in it.
+func snapshotLibrary(root string) map[string]string {
+	snapshot := make(map[string]string)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil
+		}
+		var signature strings.Builder
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&signature, "%s:%d:%d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+		}
+		snapshot[path] = signature.String()
+		return nil
+	})
+	return snapshot
+}
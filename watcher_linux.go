@@ -0,0 +1,130 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_ONLYDIR
+
+// inotifyWatcher watches every folder below a root with inotify.
+type inotifyWatcher struct {
+	fd    int
+	file  *os.File
+	mutex sync.Mutex
+	dirs  map[int32]string
+}
+
+// newNativeWatcher watches root with inotify. It fails when inotify is not
+// available or the watch limit is too low for the library, so the caller
+// can fall back to polling.
+func newNativeWatcher(root string, changes chan<- libraryChange) (libraryWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %v", err)
+	}
+
+	// A non-blocking descriptor goes through the runtime poller, so Close
+	// interrupts a pending Read
+	w := &inotifyWatcher{
+		fd:   fd,
+		file: os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int32]string),
+	}
+	if err := w.addTree(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.run(root, changes)
+	return w, nil
+}
+
+// addTree adds a watch on dir and every folder below it.
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			if err == syscall.ENOSPC {
+				return fmt.Errorf("inotify watch limit reached: %v", err)
+			}
+			return nil
+		}
+		w.mutex.Lock()
+		w.dirs[int32(wd)] = path
+		w.mutex.Unlock()
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) run(root string, changes chan<- libraryChange) {
+	defer close(changes)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// Events were lost; rescan everything
+				changes <- libraryChange{Path: root, Recursive: true}
+				continue
+			}
+
+			w.mutex.Lock()
+			dir, ok := w.dirs[event.Wd]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, event.Wd)
+			}
+			w.mutex.Unlock()
+			if !ok || event.Mask&(syscall.IN_IGNORED|syscall.IN_DELETE_SELF) != 0 {
+				continue
+			}
+
+			if event.Mask&syscall.IN_ISDIR == 0 {
+				changes <- libraryChange{Path: dir}
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				// Watch the new folder before scanning it so nothing
+				// copied into it afterwards is missed
+				w.addTree(path)
+			}
+			changes <- libraryChange{Path: path, Recursive: true}
+		}
+	}
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
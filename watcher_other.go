@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// newNativeWatcher is only implemented on Linux; other platforms poll.
+func newNativeWatcher(root string, changes chan<- libraryChange) (libraryWatcher, error) {
+	return nil, errNativeWatchUnsupported
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRefreshLibraryPath(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "watcher_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	first := filepath.Join(tempDir, "Artist", "First")
+	second := filepath.Join(tempDir, "Artist", "Second")
+	createAlbum(t, first, []string{"01.mp3"})
+	createAlbum(t, second, []string{"01.mp3"})
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		events:           newEventHub(),
+	}
+	server.storeScan(tempDir, server.scanMusicFolders(tempDir))
+	oldFingerprint := server.lastScan[0].Fingerprint
+
+	events := server.events.subscribe()
+	defer server.events.unsubscribe(events)
+	nextEvent := func() Event {
+		select {
+		case event := <-events:
+			return event
+		default:
+			return Event{}
+		}
+	}
+
+	// A track is added to one album
+	createAlbum(t, first, []string{"02.mp3"})
+	server.refreshLibraryPath(tempDir, first, false)
+	if event := nextEvent(); event.Type != "album-changed" {
+		t.Errorf("Expected album-changed, got %q", event.Type)
+	}
+	if server.lastScan[0].Mp3Count != 2 || server.lastScan[0].Fingerprint == oldFingerprint {
+		t.Errorf("Expected the album and its fingerprint to be updated, got %+v", server.lastScan[0])
+	}
+
+	// A new album folder is copied in
+	added := filepath.Join(tempDir, "Another", "Album")
+	createAlbum(t, added, []string{"01.mp3"})
+	server.refreshLibraryPath(tempDir, filepath.Join(tempDir, "Another"), true)
+	if event := nextEvent(); event.Type != "album-added" {
+		t.Errorf("Expected album-added, got %q", event.Type)
+	}
+
+	// An album is deleted
+	os.RemoveAll(second)
+	server.refreshLibraryPath(tempDir, second, true)
+	if event := nextEvent(); event.Type != "album-removed" {
+		t.Errorf("Expected album-removed, got %q", event.Type)
+	}
+
+	expected := []string{added, first}
+	if len(server.lastScan) != 2 || server.lastScan[0].Path != expected[0] || server.lastScan[1].Path != expected[1] {
+		t.Errorf("Expected %v, got %+v", expected, server.lastScan)
+	}
+	if event := nextEvent(); event.Type != "" {
+		t.Errorf("Expected no more events, got %q", event.Type)
+	}
+
+	// A refresh still running when another folder is scanned is dropped
+	other := filepath.Join(tempDir, "Other")
+	createAlbum(t, filepath.Join(other, "Artist", "Album"), []string{"01.mp3"})
+	server.storeScan(other, server.scanMusicFolders(other))
+	createAlbum(t, first, []string{"03.mp3"})
+	server.refreshLibraryPath(tempDir, first, false)
+	if len(server.lastScan) != 1 || server.lastScan[0].Path != filepath.Join(other, "Artist", "Album") {
+		t.Errorf("Expected the other folder's scan to be left alone, got %+v", server.lastScan)
+	}
+	if event := nextEvent(); event.Type != "" {
+		t.Errorf("Expected no events from the dropped refresh, got %q", event.Type)
+	}
+}
+
+func TestRefreshNestedFolderUsesLibraryRoot(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "watcher_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	server := &Server{
+		port:             "8080",
+		fingerprintCache: make(map[string]string),
+		settingsFile:     filepath.Join(tempDir, "settings.json"),
+		events:           newEventHub(),
+	}
+	library := filepath.Join(tempDir, "library")
+	if err := server.updateSettings(func(settings *AppSettings) error {
+		settings.LibraryPatterns = []string{"{genre}/{artist}/{album}"}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	createAlbum(t, filepath.Join(library, "Jazz", "Miles Davis", "Kind of Blue"), []string{"01.mp3"})
+	server.storeScan(library, server.scanMusicFolders(library))
+
+	// A genre folder with a new album is copied in; the rescan of that
+	// folder alone still matches the pattern against the library root
+	added := filepath.Join(library, "Electronic", "Air", "Moon Safari")
+	createAlbum(t, added, []string{"01.mp3"})
+	server.refreshLibraryPath(library, filepath.Join(library, "Electronic"), true)
+
+	album, ok := server.scannedAlbum(server.generateFolderFingerprint(added))
+	if !ok {
+		t.Fatalf("Expected the new album in the last scan, got %+v", server.lastScan)
+	}
+	if album.Genre != "Electronic" || album.Artist != "Air" || album.Album != "Moon Safari" {
+		t.Errorf("Expected the album named from the library root, got %+v", album)
+	}
+}
+
+func TestLibraryWatchers(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "watcher_live_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	album := filepath.Join(tempDir, "Artist", "Album")
+	createAlbum(t, album, []string{"01.mp3"})
+
+	watchers := map[string]func(chan<- libraryChange) (libraryWatcher, error){
+		"polling": func(changes chan<- libraryChange) (libraryWatcher, error) {
+			return newPollingWatcher(tempDir, 20*time.Millisecond, changes), nil
+		},
+		"native": func(changes chan<- libraryChange) (libraryWatcher, error) {
+			return newNativeWatcher(tempDir, changes)
+		},
+	}
+
+	for name, start := range watchers {
+		changes := make(chan libraryChange, 64)
+		watcher, err := start(changes)
+		if err != nil {
+			t.Logf("Skipping %s watcher: %v", name, err)
+			continue
+		}
+
+		track := filepath.Join(album, name+".mp3")
+		if err := os.WriteFile(track, []byte("audio"), 0644); err != nil {
+			t.Fatalf("Failed to write track: %v", err)
+		}
+
+		select {
+		case change := <-changes:
+			if change.Path != album {
+				t.Errorf("%s: expected a change in %s, got %+v", name, album, change)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: no change reported", name)
+		}
+
+		// Closing the watcher closes its channel
+		watcher.Close()
+		for range changes {
+		}
+	}
+}